@@ -0,0 +1,102 @@
+package saml
+
+import "strings"
+
+// AttributeMapper transforms the raw AssertionAttributes coming out of
+// ParseResponse into the canonical set that should flow into the session
+// (and, from there, into the JWT/X-Saml-* headers). It is applied once, by
+// DefaultAuthorizeFunc, before the session is created.
+type AttributeMapper struct {
+	// Rename maps an incoming attribute's FriendlyName (as the IdP sent it,
+	// e.g. "mail" or "urn:oid:0.9.2342.19200300.100.1.3") to the canonical
+	// name it should be known as from here on, e.g. "email". Attributes not
+	// mentioned here pass through under their original name.
+	Rename map[string]string
+
+	// Split maps a canonical attribute name (i.e. after Rename) to a
+	// separator string. A single incoming value for that attribute is split
+	// into one AssertionAttribute per piece, all sharing the canonical
+	// name, so that e.g. a "groups" attribute of "admins;staff" becomes two
+	// "groups" attributes.
+	Split map[string]string
+
+	// Allow, if non-empty, restricts the output to only these canonical
+	// attribute names; everything else is dropped before it can reach the
+	// JWT/headers. NameID and SessionIndex are always kept regardless of
+	// Allow, since SLO and session management depend on them.
+	Allow []string
+
+	// Policy, if set, is evaluated against the mapped attributes.
+	//   - If Tag is nil, a false result causes Apply to reject the
+	//     assertion outright (ok=false).
+	//   - If Tag is set, Apply never rejects on Policy's account; instead
+	//     the Tag attribute is appended when Policy evaluates true, e.g. to
+	//     mark a user as "staff" for downstream use by RequireAttribute.
+	Policy *Policy
+	Tag    *AssertionAttribute
+}
+
+// Apply renames, splits, and filters in, attributes and evaluates Policy,
+// returning the transformed attributes and whether the assertion should be
+// allowed to proceed at all. Policy is evaluated against the full
+// renamed/split attribute set, before Allow filtering is applied to the
+// returned attributes, so that Policy can reference an attribute even if
+// Allow would otherwise hide it from downstream consumers.
+func (am *AttributeMapper) Apply(in AssertionAttributes) (out AssertionAttributes, ok bool) {
+	for _, attr := range in {
+		name := attr.FriendlyName
+		if renamed, found := am.Rename[name]; found {
+			name = renamed
+		}
+
+		if separator, found := am.Split[name]; found && separator != "" {
+			for _, value := range strings.Split(attr.Value, separator) {
+				out = append(out, AssertionAttribute{FriendlyName: name, Value: value})
+			}
+			continue
+		}
+
+		out = append(out, AssertionAttribute{FriendlyName: name, Value: attr.Value})
+	}
+
+	var allowed bool
+	if am.Policy != nil {
+		allowed = am.Policy.Evaluate(out)
+	}
+
+	if len(am.Allow) > 0 {
+		out = filterAttributes(out, am.Allow)
+	}
+
+	if am.Policy == nil {
+		return out, true
+	}
+
+	if am.Tag != nil {
+		if allowed {
+			out = append(out, *am.Tag)
+		}
+		return out, true
+	}
+	return out, allowed
+}
+
+// filterAttributes keeps only attributes whose canonical name is in allow,
+// plus NameID/SessionIndex, which SLO and session management need
+// regardless of the allowlist.
+func filterAttributes(in AssertionAttributes, allow []string) AssertionAttributes {
+	keep := make(map[string]bool, len(allow)+2)
+	for _, name := range allow {
+		keep[name] = true
+	}
+	keep[nameIDAttributeName] = true
+	keep[sessionIndexAttributeName] = true
+
+	var out AssertionAttributes
+	for _, attr := range in {
+		if keep[attr.FriendlyName] {
+			out = append(out, attr)
+		}
+	}
+	return out
+}
@@ -0,0 +1,274 @@
+package saml
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// KeyPair is a certificate and the private key matching it, both
+// PEM-encoded, in the format ServiceProvider.Key and ServiceProvider.
+// Certificate already use.
+type KeyPair struct {
+	Certificate string
+	Key         string
+}
+
+// KeyRing holds the set of keys a service provider is willing to use for
+// XML decryption, supporting key rotation without downtime: operators add
+// the new key as Keys[0] (the new primary, used to sign SP-initiated SLO
+// messages and advertised for encryption) while the old key remains further
+// down Keys so that assertions encrypted under it before the rollover still
+// decrypt.
+type KeyRing struct {
+	Keys []KeyPair
+}
+
+// Primary returns the first key in the ring, which ServiceProviderMiddleware
+// uses to sign SP-initiated SLO messages and which is the one advertised in
+// SP metadata as the encryption certificate that IdPs should encrypt new
+// assertions to. (AuthnRequest signing is not affected by KeyRing: it is
+// handled internally by ServiceProvider.MakeRedirectAuthenticationRequest.)
+func (kr *KeyRing) Primary() (KeyPair, error) {
+	if len(kr.Keys) == 0 {
+		return KeyPair{}, fmt.Errorf("saml: KeyRing has no keys")
+	}
+	return kr.Keys[0], nil
+}
+
+// EncryptionKeyDescriptor builds the KeyDescriptor metadata element that
+// advertises kr's primary key as the certificate IdPs should use to encrypt
+// assertions for this SP, per the SAML metadata schema's use="encryption"
+// convention. Callers fold this into the SPSSODescriptor's KeyDescriptor
+// list alongside the use="signing" entry.
+func (kr *KeyRing) EncryptionKeyDescriptor() (KeyDescriptor, error) {
+	primary, err := kr.Primary()
+	if err != nil {
+		return KeyDescriptor{}, err
+	}
+
+	block, _ := pem.Decode([]byte(primary.Certificate))
+	if block == nil {
+		return KeyDescriptor{}, fmt.Errorf("saml: invalid PEM certificate")
+	}
+
+	return KeyDescriptor{
+		Use: "encryption",
+		KeyInfo: KeyInfo{
+			X509Data: X509Data{
+				X509Certificates: []X509Certificate{
+					{Data: base64.StdEncoding.EncodeToString(block.Bytes)},
+				},
+			},
+		},
+	}, nil
+}
+
+// DecryptAssertion decrypts an XML-Encrypted <saml:EncryptedAssertion>
+// element, trying every key in the ring in turn (so a rolled-over SP can
+// still decrypt assertions encrypted under its previous key), and returns
+// the decrypted <saml:Assertion> element.
+//
+// It supports RSA-OAEP and RSA-PKCS#1v1.5 key transport and AES-CBC (128,
+// 192 or 256-bit) bulk encryption, which covers the algorithms IdPs commonly use
+// for SAML assertion encryption.
+func (kr *KeyRing) DecryptAssertion(encryptedAssertion *etree.Element) (*etree.Element, error) {
+	encryptedData := encryptedAssertion.FindElement("./EncryptedData")
+	if encryptedData == nil {
+		encryptedData = encryptedAssertion.FindElement(".//EncryptedData")
+	}
+	if encryptedData == nil {
+		return nil, fmt.Errorf("saml: EncryptedAssertion has no EncryptedData")
+	}
+
+	encryptedKeyCipherValue, keyAlgorithm, err := findCipherValue(encryptedData, "./KeyInfo/EncryptedKey")
+	if err != nil {
+		return nil, err
+	}
+	dataCipherValue, dataAlgorithm, err := findCipherValue(encryptedData, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	keySize, err := bulkKeySize(dataAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, keyPair := range kr.Keys {
+		aesKey, err := decryptKeyTransport(keyPair, encryptedKeyCipherValue, keyAlgorithm, keySize)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		plaintext, err := decryptBulkData(aesKey, dataCipherValue, dataAlgorithm)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(plaintext); err != nil {
+			lastErr = fmt.Errorf("saml: parsing decrypted assertion: %s", err)
+			continue
+		}
+		return doc.Root(), nil
+	}
+	return nil, fmt.Errorf("saml: could not decrypt assertion with any key in the ring: %s", lastErr)
+}
+
+// findCipherValue locates, relative to encryptedData, the CipherValue and
+// EncryptionMethod Algorithm under the given sub-path ("." for
+// encryptedData's own CipherData, or a path like "./KeyInfo/EncryptedKey"
+// for a nested EncryptedKey).
+func findCipherValue(encryptedData *etree.Element, path string) ([]byte, string, error) {
+	el := encryptedData
+	if path != "." {
+		el = encryptedData.FindElement(path)
+		if el == nil {
+			return nil, "", fmt.Errorf("saml: EncryptedData missing %s", path)
+		}
+	}
+
+	method := el.FindElement("./EncryptionMethod")
+	if method == nil {
+		return nil, "", fmt.Errorf("saml: EncryptionMethod missing at %s", path)
+	}
+	algorithm := method.SelectAttrValue("Algorithm", "")
+
+	cipherValue := el.FindElement("./CipherData/CipherValue")
+	if cipherValue == nil {
+		return nil, "", fmt.Errorf("saml: CipherValue missing at %s", path)
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cipherValue.Text()))
+	if err != nil {
+		return nil, "", fmt.Errorf("saml: decoding CipherValue: %s", err)
+	}
+	return buf, algorithm, nil
+}
+
+// decryptKeyTransport recovers the bulk encryption key from an
+// RSA-encrypted EncryptedKey CipherValue. keySize is the expected length, in
+// bytes, of the recovered key, as determined by the bulk algorithm
+// (bulkKeySize).
+//
+// For the rsa-1_5 algorithm, this uses rsa.DecryptPKCS1v15SessionKey rather
+// than rsa.DecryptPKCS1v15: a raw PKCS#1 v1.5 decrypt returns a distinct
+// error for invalid padding, which on attacker-controlled ciphertext (as
+// EncryptedKey is, arriving unauthenticated at /saml/acs) is a Bleichenbacher
+// padding-oracle. DecryptPKCS1v15SessionKey instead always returns a
+// keySize-byte key, silently substituting random bytes on any padding or
+// length failure, so the response never signals whether the padding was
+// valid.
+func decryptKeyTransport(keyPair KeyPair, ciphertext []byte, algorithm string, keySize int) ([]byte, error) {
+	privateKey, err := parsePEMPrivateKey(keyPair.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.Contains(algorithm, "rsa-oaep"):
+		return rsa.DecryptOAEP(sha1.New(), rand.Reader, privateKey, ciphertext, nil)
+	default: // rsa-1_5 and anything unrecognized
+		key := make([]byte, keySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, fmt.Errorf("saml: generating fallback session key: %s", err)
+		}
+		if err := rsa.DecryptPKCS1v15SessionKey(rand.Reader, privateKey, ciphertext, key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+}
+
+// bulkKeySize returns the key size, in bytes, that algorithm (a bulk
+// XML-Encryption data algorithm URI) uses, so that decryptKeyTransport's
+// rsa-1_5 path knows how large a key to recover.
+func bulkKeySize(algorithm string) (int, error) {
+	switch {
+	case strings.Contains(algorithm, "aes128"):
+		return 16, nil
+	case strings.Contains(algorithm, "aes192"):
+		return 24, nil
+	case strings.Contains(algorithm, "aes256"):
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("saml: unsupported bulk encryption algorithm %q", algorithm)
+	}
+}
+
+// parsePEMPrivateKey PEM-decodes pemKey and parses the result as an RSA
+// private key.
+func parsePEMPrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, fmt.Errorf("saml: invalid PEM private key")
+	}
+	return parseRSAPrivateKey(block.Bytes)
+}
+
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("saml: parsing private key: %s", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("saml: private key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+// decryptBulkData decrypts the assertion's CipherValue with AES-CBC: the
+// first block of ciphertext is the IV, and the result is PKCS#7-unpadded.
+func decryptBulkData(key, ciphertext []byte, algorithm string) ([]byte, error) {
+	if !strings.Contains(algorithm, "aes") || !strings.Contains(algorithm, "cbc") {
+		return nil, fmt.Errorf("saml: unsupported bulk encryption algorithm %q", algorithm)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("saml: building AES cipher: %s", err)
+	}
+	if len(ciphertext) < aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("saml: invalid ciphertext length")
+	}
+
+	iv := ciphertext[:aes.BlockSize]
+	body := ciphertext[aes.BlockSize:]
+	plaintext := make([]byte, len(body))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, body)
+
+	return pkcs7Unpad(plaintext)
+}
+
+func pkcs7Unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("saml: empty plaintext")
+	}
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > len(data) {
+		return nil, fmt.Errorf("saml: invalid PKCS#7 padding")
+	}
+	if !bytes.Equal(data[len(data)-padLen:], bytes.Repeat([]byte{byte(padLen)}, padLen)) {
+		return nil, fmt.Errorf("saml: invalid PKCS#7 padding")
+	}
+	return data[:len(data)-padLen], nil
+}
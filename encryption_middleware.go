@@ -0,0 +1,61 @@
+package saml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/beevik/etree"
+)
+
+// decryptResponse rewrites the SAMLResponse form value on r in place,
+// replacing any <saml:EncryptedAssertion> with the plaintext <saml:Assertion>
+// decrypted using m.KeyRing, before ParseResponse ever sees it. If the
+// response contains no EncryptedAssertion, it is left untouched so that
+// unencrypted assertions keep working when KeyRing is configured defensively.
+func (m *ServiceProviderMiddleware) decryptResponse(r *http.Request) error {
+	if m.KeyRing == nil {
+		return nil
+	}
+
+	encoded := r.Form.Get("SAMLResponse")
+	if encoded == "" {
+		return nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("saml: decoding SAMLResponse: %s", err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return fmt.Errorf("saml: parsing SAMLResponse: %s", err)
+	}
+
+	encryptedAssertion := doc.FindElement(".//EncryptedAssertion")
+	if encryptedAssertion == nil {
+		return nil
+	}
+
+	assertion, err := m.KeyRing.DecryptAssertion(encryptedAssertion)
+	if err != nil {
+		return err
+	}
+
+	parent := encryptedAssertion.Parent()
+	parent.RemoveChild(encryptedAssertion)
+	parent.AddChild(assertion)
+
+	newRaw, err := doc.WriteToBytes()
+	if err != nil {
+		return fmt.Errorf("saml: re-serializing decrypted SAMLResponse: %s", err)
+	}
+
+	newEncoded := base64.StdEncoding.EncodeToString(newRaw)
+	r.Form.Set("SAMLResponse", newEncoded)
+	if r.PostForm != nil {
+		r.PostForm.Set("SAMLResponse", newEncoded)
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	bindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	sigAlgRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+
+	statusCodeSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+)
+
+// Endpoint is a SAML metadata endpoint: a binding and the URL that
+// implements it, as used for SingleLogoutService entries.
+type Endpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+// LogoutRequest is the <samlp:LogoutRequest> element, sent either by the SP
+// to begin an SP-initiated logout (ServiceProviderMiddleware.Logout) or by
+// the IdP to begin an IdP-initiated logout against the SP's SLO endpoint.
+type LogoutRequest struct {
+	XMLName      xml.Name  `xml:"urn:oasis:names:tc:SAML:2.0:protocol LogoutRequest"`
+	ID           string    `xml:",attr"`
+	Version      string    `xml:",attr"`
+	IssueInstant time.Time `xml:",attr"`
+	Destination  string    `xml:",attr,omitempty"`
+	Issuer       string    `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameID       string    `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	SessionIndex string    `xml:"urn:oasis:names:tc:SAML:2.0:protocol SessionIndex,omitempty"`
+}
+
+// LogoutResponse is the <samlp:LogoutResponse> element sent in reply to a
+// LogoutRequest, indicating whether the logout succeeded.
+type LogoutResponse struct {
+	XMLName      xml.Name     `xml:"urn:oasis:names:tc:SAML:2.0:protocol LogoutResponse"`
+	ID           string       `xml:",attr"`
+	Version      string       `xml:",attr"`
+	IssueInstant time.Time    `xml:",attr"`
+	Destination  string       `xml:",attr,omitempty"`
+	InResponseTo string       `xml:",attr,omitempty"`
+	Issuer       string       `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Status       LogoutStatus `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+}
+
+// LogoutStatus is the <samlp:Status> child of a LogoutResponse.
+type LogoutStatus struct {
+	StatusCode struct {
+		Value string `xml:",attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
+}
+
+// MakeRedirectLogoutRequest builds and signs a <samlp:LogoutRequest> for
+// nameID/sessionIndex (the values recorded for the session being logged
+// out) and returns the URL the user's browser should be redirected to,
+// using the HTTP-Redirect binding against the IdP's SingleLogoutService.
+func (sp *ServiceProvider) MakeRedirectLogoutRequest(nameID, sessionIndex string, metadata *EntityDescriptor, signingKey *rsa.PrivateKey) (*url.URL, error) {
+	location, err := idpSloLocation(metadata, bindingHTTPRedirect)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	req := LogoutRequest{
+		ID:           id,
+		Version:      "2.0",
+		IssueInstant: timeNow(),
+		Destination:  location,
+		Issuer:       sp.MetadataURL,
+		NameID:       nameID,
+		SessionIndex: sessionIndex,
+	}
+
+	buf, err := xml.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("saml: marshaling LogoutRequest: %s", err)
+	}
+	return signRedirectBinding(location, "SAMLRequest", buf, signingKey)
+}
+
+// MakeRedirectLogoutResponse builds and signs a <samlp:LogoutResponse>
+// reporting success, in reply to the LogoutRequest whose ID is
+// inResponseTo, and returns the URL the user's browser should be
+// redirected to.
+func (sp *ServiceProvider) MakeRedirectLogoutResponse(inResponseTo string, metadata *EntityDescriptor, signingKey *rsa.PrivateKey) (*url.URL, error) {
+	location, err := idpSloLocation(metadata, bindingHTTPRedirect)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := LogoutResponse{
+		ID:           id,
+		Version:      "2.0",
+		IssueInstant: timeNow(),
+		Destination:  location,
+		InResponseTo: inResponseTo,
+		Issuer:       sp.MetadataURL,
+	}
+	resp.Status.StatusCode.Value = statusCodeSuccess
+
+	buf, err := xml.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("saml: marshaling LogoutResponse: %s", err)
+	}
+	return signRedirectBinding(location, "SAMLResponse", buf, signingKey)
+}
+
+// ParseLogoutRequest verifies and parses the redirect-binding
+// <samlp:LogoutRequest> carried in r's SAMLRequest query parameter,
+// checking its signature against the IdP's signing certificate and that
+// Destination (if present) matches sp.SloURL.
+func (sp *ServiceProvider) ParseLogoutRequest(r *http.Request, metadata *EntityDescriptor) (*LogoutRequest, error) {
+	buf, err := verifyRedirectBinding(r, "SAMLRequest", metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var req LogoutRequest
+	if err := xml.Unmarshal(buf, &req); err != nil {
+		return nil, fmt.Errorf("saml: parsing LogoutRequest: %s", err)
+	}
+	if req.Destination != "" && req.Destination != sp.SloURL {
+		return nil, fmt.Errorf("saml: LogoutRequest Destination %q does not match SloURL %q", req.Destination, sp.SloURL)
+	}
+	return &req, nil
+}
+
+// ParseLogoutResponse verifies the redirect-binding <samlp:LogoutResponse>
+// carried in r's SAMLResponse query parameter and checks that it reports
+// success.
+func (sp *ServiceProvider) ParseLogoutResponse(r *http.Request, metadata *EntityDescriptor) error {
+	buf, err := verifyRedirectBinding(r, "SAMLResponse", metadata)
+	if err != nil {
+		return err
+	}
+
+	var resp LogoutResponse
+	if err := xml.Unmarshal(buf, &resp); err != nil {
+		return fmt.Errorf("saml: parsing LogoutResponse: %s", err)
+	}
+	if resp.Status.StatusCode.Value != statusCodeSuccess {
+		return fmt.Errorf("saml: LogoutResponse status %q is not success", resp.Status.StatusCode.Value)
+	}
+	return nil
+}
+
+// idpSloLocation returns the Location of the IdP's SingleLogoutService for
+// binding, from metadata. metadata is passed in by the caller (rather than
+// read off a ServiceProvider field) so that callers going through
+// ServiceProviderMiddleware can supply a consistent snapshot obtained under
+// its own lock, rather than racing a MetadataResolver hot-swap.
+func idpSloLocation(metadata *EntityDescriptor, binding string) (string, error) {
+	if metadata == nil || metadata.IDPSSODescriptor == nil {
+		return "", fmt.Errorf("saml: no IdP metadata available for Single Logout")
+	}
+	for _, ep := range metadata.IDPSSODescriptor.SingleLogoutServices {
+		if ep.Binding == binding {
+			return ep.Location, nil
+		}
+	}
+	return "", fmt.Errorf("saml: IdP metadata has no SingleLogoutService for binding %q", binding)
+}
+
+// idpSigningCert returns the IdP's signing certificate from metadata, used
+// to verify the Signature on an incoming redirect-binding SLO message.
+// metadata is passed in by the caller for the same reason as idpSloLocation.
+func idpSigningCert(metadata *EntityDescriptor) (*x509.Certificate, error) {
+	if metadata == nil || metadata.IDPSSODescriptor == nil {
+		return nil, fmt.Errorf("saml: no IdP metadata available to verify Single Logout signature")
+	}
+	for _, kd := range metadata.IDPSSODescriptor.KeyDescriptors {
+		if kd.Use != "" && kd.Use != "signing" {
+			continue
+		}
+		if len(kd.KeyInfo.X509Data.X509Certificates) == 0 {
+			continue
+		}
+		der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(kd.KeyInfo.X509Data.X509Certificates[0].Data))
+		if err != nil {
+			return nil, fmt.Errorf("saml: decoding IdP signing certificate: %s", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("saml: parsing IdP signing certificate: %s", err)
+		}
+		return cert, nil
+	}
+	return nil, fmt.Errorf("saml: IdP metadata has no signing KeyDescriptor")
+}
+
+// signRedirectBinding deflates message, base64-encodes it into the
+// paramName query parameter (SAMLRequest or SAMLResponse) at location, and
+// signs it per the HTTP-Redirect binding's query-string signing convention:
+// the percent-encoded "paramName=...&SigAlg=..." string is signed with
+// signingKey and the signature is appended as a final Signature parameter.
+func signRedirectBinding(location, paramName string, message []byte, signingKey *rsa.PrivateKey) (*url.URL, error) {
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return nil, fmt.Errorf("saml: compressing %s: %s", paramName, err)
+	}
+	if _, err := fw.Write(message); err != nil {
+		return nil, fmt.Errorf("saml: compressing %s: %s", paramName, err)
+	}
+	if err := fw.Close(); err != nil {
+		return nil, fmt.Errorf("saml: compressing %s: %s", paramName, err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(deflated.Bytes())
+	signingInput := paramName + "=" + url.QueryEscape(encoded) + "&SigAlg=" + url.QueryEscape(sigAlgRSASHA256)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signingKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("saml: signing %s: %s", paramName, err)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("saml: parsing SingleLogoutService location: %s", err)
+	}
+	u.RawQuery = signingInput + "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature))
+	return u, nil
+}
+
+// verifyRedirectBinding extracts and inflates the paramName query parameter
+// from r (a redirect-binding SLO request or response), verifies its
+// Signature against the IdP's signing certificate, and returns the
+// decompressed XML. A missing Signature is rejected outright: without it,
+// anyone who can reach the SLO endpoint could force-logout any NameID with
+// an unsigned, forged LogoutRequest.
+func verifyRedirectBinding(r *http.Request, paramName string, metadata *EntityDescriptor) ([]byte, error) {
+	encoded := r.Form.Get(paramName)
+	if encoded == "" {
+		return nil, fmt.Errorf("saml: request has no %s parameter", paramName)
+	}
+
+	signature := r.Form.Get("Signature")
+	if signature == "" {
+		return nil, fmt.Errorf("saml: request has no Signature parameter")
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("saml: decoding Signature: %s", err)
+	}
+	cert, err := idpSigningCert(metadata)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(signedRedirectBindingQuery(r.URL.RawQuery)))
+	if err := rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sigBytes); err != nil {
+		return nil, fmt.Errorf("saml: verifying %s signature: %s", paramName, err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("saml: decoding %s: %s", paramName, err)
+	}
+
+	reader := flate.NewReader(bytes.NewReader(raw))
+	defer reader.Close()
+	buf, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("saml: inflating %s: %s", paramName, err)
+	}
+	return buf, nil
+}
+
+// signedRedirectBindingQuery reconstructs the exact byte string that the
+// sender signed: r.URL.RawQuery with the Signature parameter removed,
+// preserving the order and percent-encoding of every other parameter
+// exactly as received (the HTTP-Redirect binding signs SAMLRequest or
+// SAMLResponse, optionally RelayState, then SigAlg, in that order, and
+// Signature is always last).
+func signedRedirectBindingQuery(rawQuery string) string {
+	var kept []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		if strings.HasPrefix(part, "Signature=") {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,162 @@
+package saml
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// logoutTestMetadata builds an EntityDescriptor advertising a single
+// HTTP-Redirect SingleLogoutService at location, with signingCert as its
+// signing KeyDescriptor, so that verifyRedirectBinding can resolve both the
+// SLO location and the certificate to verify against.
+func logoutTestMetadata(location string, signingCert *x509.Certificate) *EntityDescriptor {
+	return &EntityDescriptor{
+		IDPSSODescriptor: &IDPSSODescriptor{
+			SingleLogoutServices: []Endpoint{
+				{Binding: bindingHTTPRedirect, Location: location},
+			},
+			KeyDescriptors: []KeyDescriptor{
+				{
+					Use: "signing",
+					KeyInfo: KeyInfo{
+						X509Data: X509Data{
+							X509Certificates: []X509Certificate{
+								{Data: base64.StdEncoding.EncodeToString(signingCert.Raw)},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMakeAndParseRedirectLogoutRequestRoundTrip(t *testing.T) {
+	key, cert := selfSignedCert(t)
+	metadata := logoutTestMetadata("https://idp.example.com/slo", cert)
+
+	sp := &ServiceProvider{MetadataURL: "https://sp.example.com/metadata", SloURL: "https://idp.example.com/slo"}
+
+	redirectURL, err := sp.MakeRedirectLogoutRequest("alice@example.com", "session-index-1", metadata, key)
+	if err != nil {
+		t.Fatalf("MakeRedirectLogoutRequest: %s", err)
+	}
+	if got, want := redirectURL.Scheme+"://"+redirectURL.Host+redirectURL.Path, "https://idp.example.com/slo"; got != want {
+		t.Errorf("redirect URL = %q, want location %q", got, want)
+	}
+
+	r := httpRequestFromRedirectURL(t, redirectURL)
+	parsed, err := sp.ParseLogoutRequest(r, metadata)
+	if err != nil {
+		t.Fatalf("ParseLogoutRequest: %s", err)
+	}
+	if parsed.NameID != "alice@example.com" {
+		t.Errorf("parsed.NameID = %q, want %q", parsed.NameID, "alice@example.com")
+	}
+	if parsed.SessionIndex != "session-index-1" {
+		t.Errorf("parsed.SessionIndex = %q, want %q", parsed.SessionIndex, "session-index-1")
+	}
+}
+
+func TestMakeAndParseRedirectLogoutResponseRoundTrip(t *testing.T) {
+	key, cert := selfSignedCert(t)
+	metadata := logoutTestMetadata("https://idp.example.com/slo", cert)
+
+	sp := &ServiceProvider{MetadataURL: "https://sp.example.com/metadata"}
+
+	redirectURL, err := sp.MakeRedirectLogoutResponse("request-id-1", metadata, key)
+	if err != nil {
+		t.Fatalf("MakeRedirectLogoutResponse: %s", err)
+	}
+
+	r := httpRequestFromRedirectURL(t, redirectURL)
+	if err := sp.ParseLogoutResponse(r, metadata); err != nil {
+		t.Fatalf("ParseLogoutResponse: %s", err)
+	}
+}
+
+// TestParseLogoutRequestRejectsMissingSignature is a regression test: an
+// attacker who can reach the SLO endpoint must not be able to force a
+// logout by omitting the Signature parameter from an otherwise well-formed
+// LogoutRequest.
+func TestParseLogoutRequestRejectsMissingSignature(t *testing.T) {
+	key, cert := selfSignedCert(t)
+	metadata := logoutTestMetadata("https://idp.example.com/slo", cert)
+
+	sp := &ServiceProvider{MetadataURL: "https://sp.example.com/metadata", SloURL: "https://idp.example.com/slo"}
+
+	redirectURL, err := sp.MakeRedirectLogoutRequest("alice@example.com", "session-index-1", metadata, key)
+	if err != nil {
+		t.Fatalf("MakeRedirectLogoutRequest: %s", err)
+	}
+
+	query := redirectURL.Query()
+	query.Del("Signature")
+	redirectURL.RawQuery = query.Encode()
+
+	r := httpRequestFromRedirectURL(t, redirectURL)
+	if _, err := sp.ParseLogoutRequest(r, metadata); err == nil {
+		t.Fatalf("ParseLogoutRequest: expected an error for a LogoutRequest with no Signature, got nil")
+	}
+}
+
+func TestParseLogoutRequestRejectsWrongSigningKey(t *testing.T) {
+	key, _ := selfSignedCert(t)
+	_, wrongCert := selfSignedCert(t)
+	metadata := logoutTestMetadata("https://idp.example.com/slo", wrongCert)
+
+	sp := &ServiceProvider{MetadataURL: "https://sp.example.com/metadata", SloURL: "https://idp.example.com/slo"}
+
+	redirectURL, err := sp.MakeRedirectLogoutRequest("alice@example.com", "session-index-1", metadata, key)
+	if err != nil {
+		t.Fatalf("MakeRedirectLogoutRequest: %s", err)
+	}
+
+	r := httpRequestFromRedirectURL(t, redirectURL)
+	if _, err := sp.ParseLogoutRequest(r, metadata); err == nil {
+		t.Fatalf("ParseLogoutRequest: expected an error verifying against a certificate that didn't sign the request, got nil")
+	}
+}
+
+func httpRequestFromRedirectURL(t *testing.T, redirectURL *url.URL) *http.Request {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodGet, redirectURL.String(), nil)
+	if err != nil {
+		t.Fatalf("building request: %s", err)
+	}
+	if err := r.ParseForm(); err != nil {
+		t.Fatalf("ParseForm: %s", err)
+	}
+	return r
+}
+
+// selfSignedCert generates an RSA key and a matching self-signed
+// certificate, for use as the IdP's signing KeyDescriptor in tests.
+func selfSignedCert(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test IdP"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating self-signed certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing self-signed certificate: %s", err)
+	}
+	return key, cert
+}
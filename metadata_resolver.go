@@ -0,0 +1,224 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// MetadataResolver periodically fetches IdP metadata from a URL, verifies
+// its XML signature against a pinned trust anchor, and hot-swaps the
+// resulting EntityDescriptor into a ServiceProvider without requiring a
+// process restart.
+//
+// A MetadataResolver must be created with NewMetadataResolver.
+type MetadataResolver struct {
+	// URL is the location of the IdP metadata, typically an
+	// EntityDescriptor or an EntitiesDescriptor aggregate.
+	URL string
+
+	// RefreshInterval is how often the metadata is re-fetched. If the
+	// fetched document specifies a shorter cacheDuration or validUntil,
+	// that takes precedence for the next refresh.
+	RefreshInterval time.Duration
+
+	// TrustAnchor is the certificate used to verify the signature on the
+	// fetched metadata document. Fetched metadata whose signature does not
+	// verify against TrustAnchor is rejected and the previous, cached copy
+	// is kept.
+	TrustAnchor *x509.Certificate
+
+	// HTTPClient is used to fetch the metadata URL. http.DefaultClient is
+	// used if this is nil.
+	HTTPClient *http.Client
+
+	// EntityID, if set, selects a single IDPSSODescriptor entity out of an
+	// EntitiesDescriptor aggregate by its entityID. It is ignored when the
+	// fetched document is a single EntityDescriptor.
+	EntityID string
+
+	// OnUpdate, if set, is called with the newly resolved EntityDescriptor
+	// after each successful Refresh. ServiceProviderMiddleware uses this to
+	// hot-swap ServiceProvider.IDPMetadata.
+	OnUpdate func(*EntityDescriptor)
+
+	mu          sync.RWMutex
+	current     *EntityDescriptor
+	currentXML  []byte
+	nextRefresh time.Time
+}
+
+// NewMetadataResolver returns a MetadataResolver that fetches from url,
+// verifying the document's signature against trustAnchor and refreshing
+// every refreshInterval (subject to the document's own validUntil/
+// cacheDuration, if present and shorter).
+func NewMetadataResolver(url string, trustAnchor *x509.Certificate, refreshInterval time.Duration) *MetadataResolver {
+	return &MetadataResolver{
+		URL:             url,
+		TrustAnchor:     trustAnchor,
+		RefreshInterval: refreshInterval,
+	}
+}
+
+// Current returns the most recently resolved, verified EntityDescriptor, or
+// nil if none has been fetched yet.
+func (res *MetadataResolver) Current() *EntityDescriptor {
+	res.mu.RLock()
+	defer res.mu.RUnlock()
+	return res.current
+}
+
+// Refresh fetches the metadata URL once, verifies it, and, on success,
+// replaces Current(). On network failure or a signature/parse error, the
+// previously cached metadata (if any) is left in place and the error is
+// returned.
+func (res *MetadataResolver) Refresh() error {
+	client := res.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(res.URL)
+	if err != nil {
+		return fmt.Errorf("saml: fetching metadata: %s", err)
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("saml: reading metadata: %s", err)
+	}
+
+	if err := verifyMetadataSignature(buf, res.TrustAnchor); err != nil {
+		return err
+	}
+
+	entity, validUntil, cacheDuration, err := res.parse(buf)
+	if err != nil {
+		return err
+	}
+
+	res.mu.Lock()
+	res.current = entity
+	res.currentXML = buf
+	res.nextRefresh = computeNextRefresh(timeNow(), res.RefreshInterval, validUntil, cacheDuration)
+	res.mu.Unlock()
+
+	if res.OnUpdate != nil {
+		res.OnUpdate(entity)
+	}
+	return nil
+}
+
+// parse unmarshals buf as either an EntityDescriptor or an
+// EntitiesDescriptor aggregate and selects the right entity. The document's
+// signature must already have been verified by the caller. The returned
+// validUntil and cacheDuration are read off whichever element (the
+// aggregate or the single EntityDescriptor) actually carried them.
+func (res *MetadataResolver) parse(buf []byte) (*EntityDescriptor, time.Time, time.Duration, error) {
+	var aggregate EntitiesDescriptor
+	if err := xml.Unmarshal(buf, &aggregate); err == nil && len(aggregate.EntityDescriptors) > 0 {
+		entity, err := res.selectEntity(aggregate.EntityDescriptors)
+		if err != nil {
+			return nil, time.Time{}, 0, err
+		}
+		return entity, aggregate.ValidUntil, aggregate.CacheDuration, nil
+	}
+
+	var entity EntityDescriptor
+	if err := xml.Unmarshal(buf, &entity); err != nil {
+		return nil, time.Time{}, 0, fmt.Errorf("saml: parsing metadata: %s", err)
+	}
+	return &entity, entity.ValidUntil, entity.CacheDuration, nil
+}
+
+// computeNextRefresh returns the earliest of: now+refreshInterval, the
+// document's validUntil, and now+cacheDuration, ignoring whichever of
+// validUntil/cacheDuration is zero. This implements the documented
+// RefreshInterval doc comment: a shorter cacheDuration or validUntil in the
+// fetched document takes precedence over RefreshInterval.
+func computeNextRefresh(now time.Time, refreshInterval time.Duration, validUntil time.Time, cacheDuration time.Duration) time.Time {
+	next := now.Add(refreshInterval)
+	if !validUntil.IsZero() && validUntil.Before(next) {
+		next = validUntil
+	}
+	if cacheDuration > 0 {
+		if byCacheDuration := now.Add(cacheDuration); byCacheDuration.Before(next) {
+			next = byCacheDuration
+		}
+	}
+	return next
+}
+
+// verifyMetadataSignature checks that buf carries a valid XML signature
+// rooted at trustAnchor. Metadata with no signature, or one that does not
+// verify, is rejected: an IdP metadata URL is fetched over the network and
+// must be pinned to a trust anchor to be of any use against an
+// man-in-the-middle or compromised hosting provider.
+func verifyMetadataSignature(buf []byte, trustAnchor *x509.Certificate) error {
+	if trustAnchor == nil {
+		return fmt.Errorf("saml: MetadataResolver.TrustAnchor must be set")
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(buf); err != nil {
+		return fmt.Errorf("saml: parsing metadata XML: %s", err)
+	}
+
+	validationContext := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{trustAnchor},
+	})
+	if _, err := validationContext.Validate(doc.Root()); err != nil {
+		return fmt.Errorf("saml: verifying metadata signature: %s", err)
+	}
+	return nil
+}
+
+func (res *MetadataResolver) selectEntity(entities []EntityDescriptor) (*EntityDescriptor, error) {
+	if res.EntityID == "" {
+		return &entities[0], nil
+	}
+	for i := range entities {
+		if entities[i].EntityID == res.EntityID {
+			return &entities[i], nil
+		}
+	}
+	return nil, fmt.Errorf("saml: no EntityDescriptor with entityID %q in metadata aggregate", res.EntityID)
+}
+
+// Start begins fetching the metadata URL in a background goroutine,
+// refreshing at RefreshInterval (or sooner, per validUntil/cacheDuration)
+// until stop is closed. Refresh errors are swallowed after the first
+// successful fetch, so that a transient network failure falls back to the
+// cached copy rather than taking the IdP metadata away; callers that want
+// to observe refresh errors should call Refresh directly instead.
+func (res *MetadataResolver) Start(stop <-chan struct{}) error {
+	if err := res.Refresh(); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			res.mu.RLock()
+			wait := res.nextRefresh.Sub(timeNow())
+			res.mu.RUnlock()
+			if wait <= 0 {
+				wait = res.RefreshInterval
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+				res.Refresh() // error intentionally ignored; see doc comment
+			}
+		}
+	}()
+	return nil
+}
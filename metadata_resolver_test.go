@@ -0,0 +1,186 @@
+package saml
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+func TestComputeNextRefresh(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		refreshInterval time.Duration
+		validUntil      time.Time
+		cacheDuration   time.Duration
+		want            time.Time
+	}{
+		{
+			name:            "no validUntil or cacheDuration falls back to RefreshInterval",
+			refreshInterval: time.Hour,
+			want:            now.Add(time.Hour),
+		},
+		{
+			name:            "validUntil shorter than RefreshInterval takes precedence",
+			refreshInterval: time.Hour,
+			validUntil:      now.Add(10 * time.Minute),
+			want:            now.Add(10 * time.Minute),
+		},
+		{
+			name:            "validUntil longer than RefreshInterval is ignored",
+			refreshInterval: time.Hour,
+			validUntil:      now.Add(2 * time.Hour),
+			want:            now.Add(time.Hour),
+		},
+		{
+			name:            "cacheDuration shorter than RefreshInterval takes precedence",
+			refreshInterval: time.Hour,
+			cacheDuration:   5 * time.Minute,
+			want:            now.Add(5 * time.Minute),
+		},
+		{
+			name:            "cacheDuration longer than RefreshInterval is ignored",
+			refreshInterval: time.Hour,
+			cacheDuration:   2 * time.Hour,
+			want:            now.Add(time.Hour),
+		},
+		{
+			name:            "the shortest of all three wins",
+			refreshInterval: time.Hour,
+			validUntil:      now.Add(20 * time.Minute),
+			cacheDuration:   5 * time.Minute,
+			want:            now.Add(5 * time.Minute),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeNextRefresh(now, tt.refreshInterval, tt.validUntil, tt.cacheDuration)
+			if !got.Equal(tt.want) {
+				t.Errorf("computeNextRefresh() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMetadataResolverRefreshHonorsCacheDuration serves a signed
+// EntityDescriptor whose cacheDuration is much shorter than RefreshInterval
+// and checks that Refresh schedules the next refresh by cacheDuration rather
+// than RefreshInterval.
+func TestMetadataResolverRefreshHonorsCacheDuration(t *testing.T) {
+	trustAnchor, buf := signedMetadata(t, "https://idp.example.com/metadata", time.Time{}, 5*time.Minute)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf)
+	}))
+	defer server.Close()
+
+	res := NewMetadataResolver(server.URL, trustAnchor, time.Hour)
+	if err := res.Refresh(); err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+
+	if res.Current() == nil {
+		t.Fatalf("Current() = nil after a successful Refresh")
+	}
+
+	wait := res.nextRefresh.Sub(timeNow())
+	if wait <= 0 || wait > 5*time.Minute {
+		t.Errorf("nextRefresh is %s away, want roughly 5m (cacheDuration), not RefreshInterval's 1h", wait)
+	}
+}
+
+// TestMetadataResolverRefreshHonorsValidUntil is the validUntil analogue of
+// the cacheDuration test above.
+func TestMetadataResolverRefreshHonorsValidUntil(t *testing.T) {
+	validUntil := timeNow().Add(10 * time.Minute)
+	trustAnchor, buf := signedMetadata(t, "https://idp.example.com/metadata", validUntil, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf)
+	}))
+	defer server.Close()
+
+	res := NewMetadataResolver(server.URL, trustAnchor, time.Hour)
+	if err := res.Refresh(); err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+
+	if !res.nextRefresh.Equal(validUntil) {
+		t.Errorf("nextRefresh = %s, want validUntil %s", res.nextRefresh, validUntil)
+	}
+}
+
+func TestMetadataResolverRefreshRejectsUntrustedSignature(t *testing.T) {
+	_, buf := signedMetadata(t, "https://idp.example.com/metadata", time.Time{}, 0)
+
+	// A different trust anchor than the one the metadata was actually
+	// signed with: Refresh must reject it rather than accept unverified
+	// (or wrongly-verified) metadata.
+	wrongAnchor, _ := signedMetadata(t, "https://other.example.com/metadata", time.Time{}, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf)
+	}))
+	defer server.Close()
+
+	res := NewMetadataResolver(server.URL, wrongAnchor, time.Hour)
+	if err := res.Refresh(); err == nil {
+		t.Fatalf("Refresh: expected an error verifying metadata signed by an untrusted key, got nil")
+	}
+	if res.Current() != nil {
+		t.Errorf("Current() = %v, want nil after a failed Refresh", res.Current())
+	}
+}
+
+// signedMetadata builds a minimal signed EntityDescriptor document for
+// entityID, optionally carrying validUntil and/or cacheDuration, and returns
+// the certificate it was signed with (for use as TrustAnchor) and the
+// serialized, signed XML.
+func signedMetadata(t *testing.T, entityID string, validUntil time.Time, cacheDuration time.Duration) (*x509.Certificate, []byte) {
+	t.Helper()
+
+	keyStore := dsig.RandomKeyStoreForTest()
+	_, certDER, err := keyStore.GetKeyPair()
+	if err != nil {
+		t.Fatalf("GetKeyPair: %s", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatalf("parsing test certificate: %s", err)
+	}
+
+	doc := etree.NewDocument()
+	root := doc.CreateElement("EntityDescriptor")
+	root.CreateAttr("entityID", entityID)
+	if !validUntil.IsZero() {
+		text, err := validUntil.MarshalText()
+		if err != nil {
+			t.Fatalf("marshaling validUntil: %s", err)
+		}
+		root.CreateAttr("validUntil", string(text))
+	}
+	if cacheDuration != 0 {
+		root.CreateAttr("cacheDuration", fmt.Sprintf("%d", int64(cacheDuration)))
+	}
+
+	signingContext := dsig.NewDefaultSigningContext(keyStore)
+	signed, err := signingContext.SignEnveloped(root)
+	if err != nil {
+		t.Fatalf("signing metadata: %s", err)
+	}
+	doc.SetRoot(signed)
+
+	out, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatalf("serializing signed metadata: %s", err)
+	}
+	return cert, out
+}
@@ -1,13 +1,16 @@
 package saml
 
 import (
+	"crypto/rsa"
 	"encoding/pem"
 	"encoding/xml"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -16,8 +19,8 @@ import (
 // ServiceProviderMiddleware implements middleware than allows a web application
 // to support SAML.
 //
-// It implements http.Handler so that it can provide the metadata and ACS endpoints,
-// typically /saml/metadata and /saml/acs, respectively.
+// It implements http.Handler so that it can provide the metadata, ACS, and SLO
+// endpoints, typically /saml/metadata, /saml/acs, and /saml/slo, respectively.
 //
 // It also provides middleware, RequireAccountMiddleware which redirects users to
 // the auth process if they do not have session credentials.
@@ -27,22 +30,139 @@ import (
 // AuthorizeFunc (called when the SAML response is received). The default
 // implementations of these functions issue and verify a signed cookie containing
 // information from the SAML assertion.
+//
+// Single Logout is handled by the Logout method, which begins an SP-initiated
+// logout, and by ServeHTTP, which handles IdP-initiated LogoutRequests and the
+// LogoutResponse returned from an SP-initiated logout. SessionTerminator, if
+// set, is invoked to tear down any local session state associated with a
+// NameID/SessionIndex pair when a LogoutRequest is received.
+//
+// Session state itself is held by SessionStore, which DefaultAuthorizeFunc
+// and DefaultIsAuthorized use to create and look up sessions; only the
+// opaque id SessionStore.Create returns is ever placed in the session
+// cookie. If SessionStore is nil, a CookieSessionStore keyed on
+// ServiceProvider.Key is used, which preserves the original
+// signed-JWT-in-cookie behavior.
 type ServiceProviderMiddleware struct {
-	ServiceProvider  *ServiceProvider
-	IsAuthorizedFunc func(r *http.Request) bool
-	AuthorizeFunc    func(w http.ResponseWriter, r *http.Request, assertionAttributes AssertionAttributes)
+	ServiceProvider   *ServiceProvider
+	IsAuthorizedFunc  func(r *http.Request) bool
+	AuthorizeFunc     func(w http.ResponseWriter, r *http.Request, assertionAttributes AssertionAttributes)
+	SessionTerminator SessionTerminator
+	SessionStore      SessionStore
+	MetadataResolver  *MetadataResolver
+	AttributeMapper   *AttributeMapper
+
+	// KeyRing, if set, is used to decrypt incoming <saml:EncryptedAssertion>
+	// elements before they reach ParseResponse, trying every key in the
+	// ring so that assertions encrypted before a key rotation still
+	// decrypt. KeyRing.Primary() is also advertised as the SP's encryption
+	// certificate in the metadata ServeHTTP serves (see
+	// EncryptionKeyDescriptor).
+	KeyRing *KeyRing
+
+	// ReplayCache rejects a Response/Assertion whose assertion ID has
+	// already been presented to the ACS endpoint. If nil, a
+	// MemoryReplayCache is used.
+	ReplayCache ReplayCache
+
+	// RateLimiter, if set, is consulted before processing a POST to the
+	// ACS endpoint; requests it declines receive a 429.
+	RateLimiter RateLimiter
+
+	defaultReplayCacheOnce sync.Once
+	defaultReplayCache     *MemoryReplayCache
+
+	// idpMetadataMu guards writes to ServiceProvider.IDPMetadata made by a
+	// MetadataResolver's background refresh goroutine, so that concurrent
+	// hot-swaps (e.g. a manual Refresh racing the refresh goroutine) don't
+	// corrupt the field the way MetadataResolver's own current field is
+	// already protected by its mutex.
+	idpMetadataMu sync.Mutex
+}
+
+// replayCache returns m.ReplayCache, or a lazily-created MemoryReplayCache
+// shared across calls if none was configured.
+func (m *ServiceProviderMiddleware) replayCache() ReplayCache {
+	if m.ReplayCache != nil {
+		return m.ReplayCache
+	}
+	m.defaultReplayCacheOnce.Do(func() {
+		m.defaultReplayCache = NewMemoryReplayCache(replayCacheDefaultMaxEntries)
+	})
+	return m.defaultReplayCache
+}
+
+const replayCacheDefaultMaxEntries = 10000
+
+// UseMetadataResolver starts resolver, wiring its OnUpdate hook so that
+// every successful metadata refresh hot-swaps m.ServiceProvider.IDPMetadata,
+// picking up key rollover and other changes without a restart. It should be
+// called once, after ServiceProvider has been otherwise configured, and
+// before ServeHTTP starts handling requests. stop may be closed to stop the
+// background refresh goroutine.
+func (m *ServiceProviderMiddleware) UseMetadataResolver(resolver *MetadataResolver, stop <-chan struct{}) error {
+	resolver.OnUpdate = func(entity *EntityDescriptor) {
+		m.idpMetadataMu.Lock()
+		m.ServiceProvider.IDPMetadata = entity
+		m.idpMetadataMu.Unlock()
+	}
+	m.MetadataResolver = resolver
+	return resolver.Start(stop)
+}
+
+// idpMetadata returns a consistent snapshot of m.ServiceProvider.IDPMetadata,
+// synchronized against the same idpMetadataMu that UseMetadataResolver's
+// OnUpdate hook uses, so that SLO logic never reads the field while a
+// background refresh is hot-swapping it.
+func (m *ServiceProviderMiddleware) idpMetadata() *EntityDescriptor {
+	m.idpMetadataMu.Lock()
+	defer m.idpMetadataMu.Unlock()
+	return m.ServiceProvider.IDPMetadata
+}
+
+// signingKey returns the RSA private key used to sign SP-initiated SLO
+// messages: m.KeyRing's primary key, if a KeyRing is configured, so that key
+// rotation via KeyRing also rotates the SLO signing key; m.ServiceProvider's
+// own Key otherwise.
+func (m *ServiceProviderMiddleware) signingKey() (*rsa.PrivateKey, error) {
+	if m.KeyRing != nil {
+		primary, err := m.KeyRing.Primary()
+		if err != nil {
+			return nil, err
+		}
+		return parsePEMPrivateKey(primary.Key)
+	}
+	return parsePEMPrivateKey(m.ServiceProvider.Key)
+}
+
+// sessionStore returns m.SessionStore, or a CookieSessionStore using the
+// service provider's key if none was configured.
+func (m *ServiceProviderMiddleware) sessionStore() SessionStore {
+	if m.SessionStore != nil {
+		return m.SessionStore
+	}
+	return NewCookieSessionStore(m.ServiceProvider.Key)
+}
+
+// SessionTerminator is invoked when an IdP-initiated LogoutRequest is
+// received, so that the service provider can tear down whatever local
+// session state it has associated with the given NameID and SessionIndex.
+// Implementations should be idempotent, since IdP-initiated logout may be
+// retried.
+type SessionTerminator interface {
+	Terminate(nameID, sessionIndex string) error
 }
 
 const cookieMaxAge = time.Hour // TODO(ross): must be configurable
 const cookieName = "token"
 
 // ServeHTTP implements http.Handler and serves the SAML-specific HTTP endpoints
-// on the URIs specified by m.ServiceProvider.MetadataURL and
-// m.ServiceProvider.AcsURL.
+// on the URIs specified by m.ServiceProvider.MetadataURL, m.ServiceProvider.AcsURL,
+// and m.ServiceProvider.SloURL.
 func (m *ServiceProviderMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	metadataURL, _ := url.Parse(m.ServiceProvider.MetadataURL)
 	if r.URL.Path == metadataURL.Path {
-		metadata := m.ServiceProvider.Metadata()
+		metadata := m.buildMetadata()
 		buf, _ := xml.MarshalIndent(metadata, "", "  ")
 		w.Write(buf)
 		return
@@ -50,10 +170,25 @@ func (m *ServiceProviderMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Req
 
 	acsURL, _ := url.Parse(m.ServiceProvider.AcsURL)
 	if r.URL.Path == acsURL.Path {
+		if m.RateLimiter != nil && !m.RateLimiter.Allow(clientIP(r)) {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
 		r.ParseForm()
 
-		requestID := "" // XXX
-		assertionAttributes, err := m.ServiceProvider.ParseResponse(r, requestID)
+		if err := m.decryptResponse(r); err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// No InResponseTo enforcement: MakeRedirectAuthenticationRequest
+		// (below, in RequireAccountMiddleware) assigns the outgoing
+		// AuthnRequest's ID itself and does not report it back to us, so we
+		// have no legitimate request ID to compare the response against.
+		// Replay protection for the assertion itself is still enforced by
+		// checkReplay, below.
+		assertionAttributes, err := m.ServiceProvider.ParseResponse(r, "")
 		if err != nil {
 			if parseErr, ok := err.(*InvalidResponseError); ok {
 				log.Printf("RESPONSE: ===\n%s\n===\nNOW: %s\nERROR: %s",
@@ -63,6 +198,14 @@ func (m *ServiceProviderMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Req
 			return
 		}
 
+		if replayed, err := m.checkReplay(assertionAttributes); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if replayed {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
 		authorizeFunc := m.AuthorizeFunc
 		if authorizeFunc == nil {
 			authorizeFunc = m.DefaultAuthorizeFunc
@@ -71,9 +214,130 @@ func (m *ServiceProviderMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	sloURL, _ := url.Parse(m.ServiceProvider.SloURL)
+	if r.URL.Path == sloURL.Path {
+		r.ParseForm()
+		m.serveSlo(w, r)
+		return
+	}
+
 	http.NotFoundHandler().ServeHTTP(w, r)
 }
 
+// buildMetadata returns m.ServiceProvider.Metadata() with the SP's
+// SingleLogoutService endpoints and, if m.KeyRing is configured, its
+// encryption KeyDescriptor folded into the SPSSODescriptor, so that
+// operators get SLO and encryption-key advertisement automatically rather
+// than having to splice them in themselves.
+func (m *ServiceProviderMiddleware) buildMetadata() *EntityDescriptor {
+	metadata := m.ServiceProvider.Metadata()
+
+	if m.ServiceProvider.SloURL != "" {
+		metadata.SPSSODescriptor.SingleLogoutServices = append(metadata.SPSSODescriptor.SingleLogoutServices,
+			Endpoint{Binding: bindingHTTPRedirect, Location: m.ServiceProvider.SloURL},
+			Endpoint{Binding: bindingHTTPPost, Location: m.ServiceProvider.SloURL},
+		)
+	}
+
+	if m.KeyRing != nil {
+		if keyDescriptor, err := m.KeyRing.EncryptionKeyDescriptor(); err == nil {
+			metadata.SPSSODescriptor.KeyDescriptors = append(metadata.SPSSODescriptor.KeyDescriptors, keyDescriptor)
+		}
+	}
+
+	return metadata
+}
+
+// serveSlo handles the two kinds of request that can arrive at the SLO
+// endpoint: an IdP-initiated LogoutRequest, and the LogoutResponse that the
+// IdP sends back in reply to an SP-initiated LogoutRequest started by
+// Logout.
+func (m *ServiceProviderMiddleware) serveSlo(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Form.Get("SAMLRequest") != "":
+		logoutRequest, err := m.ServiceProvider.ParseLogoutRequest(r, m.idpMetadata())
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if m.SessionTerminator != nil {
+			if err := m.SessionTerminator.Terminate(logoutRequest.NameID, logoutRequest.SessionIndex); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		m.clearSession(w, r)
+
+		signingKey, err := m.signingKey()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logoutResponse, err := m.ServiceProvider.MakeRedirectLogoutResponse(logoutRequest.ID, m.idpMetadata(), signingKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, logoutResponse.String(), http.StatusFound)
+		return
+
+	case r.Form.Get("SAMLResponse") != "":
+		if err := m.ServiceProvider.ParseLogoutResponse(r, m.idpMetadata()); err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		m.clearSession(w, r)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+
+	default:
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	}
+}
+
+// Logout begins an SP-initiated logout: it constructs and signs a
+// LogoutRequest addressed to the IdP using the NameID and SessionIndex
+// recorded for the current session, destroys the session, and redirects
+// the user's browser to the IdP.
+func (m *ServiceProviderMiddleware) Logout(w http.ResponseWriter, r *http.Request) {
+	var nameID, sessionIndex string
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		if session, err := m.sessionStore().Get(cookie.Value); err == nil {
+			nameID, sessionIndex = session.NameID, session.SessionIndex
+		}
+	}
+
+	signingKey, err := m.signingKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectURL, err := m.ServiceProvider.MakeRedirectLogoutRequest(nameID, sessionIndex, m.idpMetadata(), signingKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.clearSession(w, r)
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// clearSession destroys the session referenced by the session cookie, if
+// any, and removes the cookie itself.
+func (m *ServiceProviderMiddleware) clearSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		m.sessionStore().Destroy(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   cookieName,
+		Value:  "",
+		MaxAge: -1,
+		Path:   "/",
+	})
+}
+
 // RequireAccountMiddleware is HTTP middleware that requires that each request be
 // associated with a valid session. If the request is not associated with a valid
 // session, then rather than serve the request, the middlware redirects the user
@@ -119,9 +383,9 @@ func (m *ServiceProviderMiddleware) RequireAccountMiddleware(handler http.Handle
 }
 
 // DefaultAuthorizeFunc is the default implementation of AuthorizeFunc. This function
-// is invoked by ServeHTTP when we have a new, valid SAML assertion. It sets a cookie
-// that contains a signed JWT containing the assertion attributes. It then redirects the
-// user's browser to the original URL contained in RelayState.
+// is invoked by ServeHTTP when we have a new, valid SAML assertion. It creates a
+// session via m.SessionStore and sets a cookie containing the session id. It then
+// redirects the user's browser to the original URL contained in RelayState.
 func (m *ServiceProviderMiddleware) DefaultAuthorizeFunc(w http.ResponseWriter, r *http.Request, assertionAttributes AssertionAttributes) {
 	secretBlock, _ := pem.Decode([]byte(m.ServiceProvider.Key))
 
@@ -137,19 +401,24 @@ func (m *ServiceProviderMiddleware) DefaultAuthorizeFunc(w http.ResponseWriter,
 		redirectURI = relayState.Claims["uri"].(string)
 	}
 
-	token := jwt.New(jwt.GetSigningMethod("HS256"))
-	for _, attr := range assertionAttributes {
-		token.Claims[attr.FriendlyName] = attr.Value
+	if m.AttributeMapper != nil {
+		mapped, ok := m.AttributeMapper.Apply(assertionAttributes)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		assertionAttributes = mapped
 	}
-	token.Claims["exp"] = timeNow().Add(cookieMaxAge).Unix()
-	signedToken, err := token.SignedString(secretBlock.Bytes)
+
+	id, err := m.sessionStore().Create(assertionAttributes)
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     cookieName,
-		Value:    signedToken,
+		Value:    id,
 		MaxAge:   int(cookieMaxAge.Seconds()),
 		HttpOnly: false,
 		Path:     "/",
@@ -158,6 +427,49 @@ func (m *ServiceProviderMiddleware) DefaultAuthorizeFunc(w http.ResponseWriter,
 	http.Redirect(w, r, redirectURI, http.StatusFound)
 }
 
+// nameIDAttributeName, sessionIndexAttributeName, assertionIDAttributeName,
+// and notOnOrAfterAttributeName are the FriendlyName values ParseResponse
+// uses for the pseudo-attributes it synthesizes from fields of the
+// assertion that aren't themselves SAML attributes, so that they can flow
+// through the same AssertionAttributes slice as everything else.
+const nameIDAttributeName = "NameID"
+const sessionIndexAttributeName = "SessionIndex"
+const assertionIDAttributeName = "AssertionID"
+const notOnOrAfterAttributeName = "NotOnOrAfter"
+
+// clientIP returns the requesting client's IP address, for use with
+// RateLimiter.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// checkReplay consults m.replayCache() using the AssertionID and
+// NotOnOrAfter pseudo-attributes synthesized by ParseResponse, reporting
+// whether this assertion has already been presented to the ACS endpoint.
+func (m *ServiceProviderMiddleware) checkReplay(assertionAttributes AssertionAttributes) (bool, error) {
+	var id string
+	var notOnOrAfter time.Time
+	for _, attr := range assertionAttributes {
+		switch attr.FriendlyName {
+		case assertionIDAttributeName:
+			id = attr.Value
+		case notOnOrAfterAttributeName:
+			notOnOrAfter, _ = time.Parse(time.RFC3339, attr.Value)
+		}
+	}
+	if id == "" {
+		return false, nil
+	}
+	if notOnOrAfter.IsZero() {
+		notOnOrAfter = timeNow().Add(cookieMaxAge)
+	}
+	return m.replayCache().Seen(id, notOnOrAfter)
+}
+
 // DefaultIsAuthorized is the default implementation of IsAuthorizedFunc. This
 // function is invoked by RequireAccountMiddleware to determine if the request
 // is already authorized or if the user's browser should be redirected to the
@@ -166,7 +478,7 @@ func (m *ServiceProviderMiddleware) DefaultAuthorizeFunc(w http.ResponseWriter,
 // if an attribute "uid" has the value "alice@example.com", then the following
 // header would be added to the request:
 //
-//     X-Saml-Uid: alice@example.com
+//	X-Saml-Uid: alice@example.com
 //
 // It is an error for this function to be invoked with a request containing
 // any headers starting with X-Saml. This function will panic if you do.
@@ -175,11 +487,9 @@ func (m *ServiceProviderMiddleware) DefaultIsAuthorized(r *http.Request) bool {
 	if err != nil {
 		return false
 	}
-	token, err := jwt.Parse(cookie.Value, func(t *jwt.Token) (interface{}, error) {
-		secretBlock, _ := pem.Decode([]byte(m.ServiceProvider.Key))
-		return secretBlock.Bytes, nil
-	})
-	if err != nil || !token.Valid {
+	store := m.sessionStore()
+	session, err := store.Get(cookie.Value)
+	if err != nil {
 		return false
 	}
 
@@ -192,11 +502,10 @@ func (m *ServiceProviderMiddleware) DefaultIsAuthorized(r *http.Request) bool {
 		}
 	}
 
-	for claimName, claimValue := range token.Claims {
-		if c, ok := claimValue.(string); ok {
-			r.Header.Set(fmt.Sprintf("X-Saml-%s", claimName), c)
-		}
+	for _, attr := range session.Attributes {
+		r.Header.Add(fmt.Sprintf("X-Saml-%s", attr.FriendlyName), attr.Value)
 	}
+	store.Refresh(cookie.Value)
 	return true
 }
 
@@ -207,9 +516,8 @@ func (m *ServiceProviderMiddleware) DefaultIsAuthorized(r *http.Request) bool {
 //
 // For example:
 //
-//     goji.Use(m.RequireAccountMiddleware)
-//     goji.Use(RequireAttributeMiddleware("eduPersonAffiliation", "Staff"))
-//
+//	goji.Use(m.RequireAccountMiddleware)
+//	goji.Use(RequireAttribute("eduPersonAffiliation", "Staff"))
 func RequireAttribute(name, value string) func(http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
@@ -225,4 +533,4 @@ func RequireAttribute(name, value string) func(http.Handler) http.Handler {
 		}
 		return http.HandlerFunc(fn)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,161 @@
+package saml
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Policy is a compiled boolean expression over assertion attributes, of the
+// form:
+//
+//	groups contains "staff" && email endsWith "@example.com"
+//
+// Supported operators are "contains" (true if any value of the named
+// attribute equals the literal — despite the name, comparison is by exact
+// match, mirroring how a multi-valued "groups" attribute is tested for
+// membership) and "endsWith" (true if any value of the named attribute ends
+// with the literal). Terms combine with "&&" and "||", evaluated left to
+// right with "&&" binding tighter than "||"; there is no parenthesization.
+type Policy struct {
+	root policyExpr
+}
+
+// policyExpr is one node of a compiled Policy: either an "or" of
+// policyAndExpr, or (within that) an "and" of policyTerm.
+type policyExpr struct {
+	ands []policyAndExpr
+}
+
+type policyAndExpr struct {
+	terms []policyTerm
+}
+
+type policyTerm struct {
+	attribute string
+	op        string // "contains" or "endsWith"
+	literal   string
+}
+
+// CompilePolicy parses expr into a Policy. It returns an error if expr is
+// not well-formed.
+func CompilePolicy(expr string) (*Policy, error) {
+	tokens, err := tokenizePolicy(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &policyParser{tokens: tokens}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("saml: unexpected token %q in policy expression", p.tokens[p.pos])
+	}
+	return &Policy{root: root}, nil
+}
+
+// Evaluate reports whether attrs satisfies the policy.
+func (p *Policy) Evaluate(attrs AssertionAttributes) bool {
+	values := map[string][]string{}
+	for _, attr := range attrs {
+		values[attr.FriendlyName] = append(values[attr.FriendlyName], attr.Value)
+	}
+	return p.root.evaluate(values)
+}
+
+// EvaluateHeader reports whether the policy is satisfied by the X-Saml-*
+// headers that DefaultIsAuthorized set on r, reusing the same evaluator as
+// Evaluate so that RequirePolicy behaves identically to an AttributeMapper
+// Policy evaluated at authorize time.
+//
+// Headers are looked up by canonicalizing "X-Saml-"+name per attribute the
+// policy actually references, the same way RequireAttribute does, rather
+// than by reverse-trimming "X-Saml-" off of r.Header's (already
+// canonicalized) keys: http.Header.Set title-cases every hyphen-separated
+// word, so an attribute like "eduPersonAffiliation" is stored under
+// "X-Saml-Edupersonaffiliation" and would never match its own name if
+// recovered by trimming.
+func (p *Policy) EvaluateHeader(r *http.Request) bool {
+	names := map[string]bool{}
+	p.root.attributeNames(names)
+
+	values := map[string][]string{}
+	for name := range names {
+		values[name] = r.Header[http.CanonicalHeaderKey(fmt.Sprintf("X-Saml-%s", name))]
+	}
+	return p.root.evaluate(values)
+}
+
+func (e policyExpr) attributeNames(set map[string]bool) {
+	for _, and := range e.ands {
+		and.attributeNames(set)
+	}
+}
+
+func (a policyAndExpr) attributeNames(set map[string]bool) {
+	for _, term := range a.terms {
+		set[term.attribute] = true
+	}
+}
+
+func (e policyExpr) evaluate(values map[string][]string) bool {
+	for _, and := range e.ands {
+		if and.evaluate(values) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a policyAndExpr) evaluate(values map[string][]string) bool {
+	for _, term := range a.terms {
+		if !term.evaluate(values) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t policyTerm) evaluate(values map[string][]string) bool {
+	for _, value := range values[t.attribute] {
+		switch t.op {
+		case "contains":
+			if value == t.literal {
+				return true
+			}
+		case "endsWith":
+			if strings.HasSuffix(value, t.literal) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequirePolicy returns a middleware function that requires that the
+// assertion attributes set as X-Saml-* headers by DefaultIsAuthorized
+// satisfy expr. It requires that RequireAccountMiddleware be used first, so
+// that the headers are present. RequirePolicy panics if expr does not
+// compile, since that is a programming error caught at setup time.
+//
+// For example:
+//
+//	goji.Use(m.RequireAccountMiddleware)
+//	goji.Use(saml.RequirePolicy(`groups contains "staff" && email endsWith "@example.com"`))
+func RequirePolicy(expr string) func(http.Handler) http.Handler {
+	policy, err := CompilePolicy(expr)
+	if err != nil {
+		panic(err)
+	}
+	return func(handler http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if policy.EvaluateHeader(r) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,128 @@
+package saml
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tokenizePolicy splits a policy expression into tokens: identifiers,
+// quoted string literals, and the operators "&&", "||", "contains", and
+// "endsWith". It is deliberately minimal; there is no parenthesization.
+func tokenizePolicy(expr string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		switch {
+		case unicode.IsSpace(runes[i]):
+			i++
+
+		case runes[i] == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("saml: unterminated string literal in policy expression")
+			}
+			tokens = append(tokens, string(runes[i:end+1]))
+			i = end + 1
+
+		case strings.HasPrefix(string(runes[i:]), "&&"):
+			tokens = append(tokens, "&&")
+			i += 2
+
+		case strings.HasPrefix(string(runes[i:]), "||"):
+			tokens = append(tokens, "||")
+			i += 2
+
+		case unicode.IsLetter(runes[i]) || runes[i] == '_':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end
+
+		default:
+			return nil, fmt.Errorf("saml: unexpected character %q in policy expression", runes[i])
+		}
+	}
+	return tokens, nil
+}
+
+// policyParser is a small recursive-descent parser over the token stream
+// produced by tokenizePolicy.
+type policyParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *policyParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *policyParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// parseExpr parses a policyAndExpr ("||" policyAndExpr)*.
+func (p *policyParser) parseExpr() (policyExpr, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return policyExpr{}, err
+	}
+	expr := policyExpr{ands: []policyAndExpr{first}}
+	for p.peek() == "||" {
+		p.next()
+		and, err := p.parseAnd()
+		if err != nil {
+			return policyExpr{}, err
+		}
+		expr.ands = append(expr.ands, and)
+	}
+	return expr, nil
+}
+
+// parseAnd parses a policyTerm ("&&" policyTerm)*.
+func (p *policyParser) parseAnd() (policyAndExpr, error) {
+	first, err := p.parseTerm()
+	if err != nil {
+		return policyAndExpr{}, err
+	}
+	and := policyAndExpr{terms: []policyTerm{first}}
+	for p.peek() == "&&" {
+		p.next()
+		term, err := p.parseTerm()
+		if err != nil {
+			return policyAndExpr{}, err
+		}
+		and.terms = append(and.terms, term)
+	}
+	return and, nil
+}
+
+// parseTerm parses IDENT ("contains"|"endsWith") STRING.
+func (p *policyParser) parseTerm() (policyTerm, error) {
+	attribute := p.next()
+	if attribute == "" {
+		return policyTerm{}, fmt.Errorf("saml: expected attribute name in policy expression")
+	}
+
+	op := p.next()
+	if op != "contains" && op != "endsWith" {
+		return policyTerm{}, fmt.Errorf("saml: expected \"contains\" or \"endsWith\" after %q, got %q", attribute, op)
+	}
+
+	literal := p.next()
+	if len(literal) < 2 || !strings.HasPrefix(literal, `"`) || !strings.HasSuffix(literal, `"`) {
+		return policyTerm{}, fmt.Errorf("saml: expected quoted string after %q, got %q", op, literal)
+	}
+
+	return policyTerm{attribute: attribute, op: op, literal: literal[1 : len(literal)-1]}, nil
+}
@@ -0,0 +1,54 @@
+package saml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizePolicy(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{
+			expr: `groups contains "staff"`,
+			want: []string{"groups", "contains", `"staff"`},
+		},
+		{
+			expr: `groups contains "staff" && email endsWith "@example.com"`,
+			want: []string{"groups", "contains", `"staff"`, "&&", "email", "endsWith", `"@example.com"`},
+		},
+		{
+			expr: `a contains "x" || b contains "y"`,
+			want: []string{"a", "contains", `"x"`, "||", "b", "contains", `"y"`},
+		},
+		{
+			expr: `  groups   contains   "staff"  `,
+			want: []string{"groups", "contains", `"staff"`},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := tokenizePolicy(tt.expr)
+		if err != nil {
+			t.Errorf("tokenizePolicy(%q): unexpected error: %s", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenizePolicy(%q) = %#v, want %#v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizePolicyErrors(t *testing.T) {
+	tests := []string{
+		`groups contains "unterminated`,
+		`groups contains #invalid`,
+	}
+
+	for _, expr := range tests {
+		if _, err := tokenizePolicy(expr); err == nil {
+			t.Errorf("tokenizePolicy(%q): expected error, got nil", expr)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package saml
+
+import "testing"
+
+func TestCompilePolicyAndEvaluate(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		attrs AssertionAttributes
+		want  bool
+	}{
+		{
+			name: "single contains match",
+			expr: `groups contains "staff"`,
+			attrs: AssertionAttributes{
+				{FriendlyName: "groups", Value: "staff"},
+			},
+			want: true,
+		},
+		{
+			name: "single contains no match",
+			expr: `groups contains "staff"`,
+			attrs: AssertionAttributes{
+				{FriendlyName: "groups", Value: "students"},
+			},
+			want: false,
+		},
+		{
+			name: "endsWith match",
+			expr: `email endsWith "@example.com"`,
+			attrs: AssertionAttributes{
+				{FriendlyName: "email", Value: "alice@example.com"},
+			},
+			want: true,
+		},
+		{
+			name: "and requires both terms",
+			expr: `groups contains "staff" && email endsWith "@example.com"`,
+			attrs: AssertionAttributes{
+				{FriendlyName: "groups", Value: "staff"},
+				{FriendlyName: "email", Value: "alice@other.com"},
+			},
+			want: false,
+		},
+		{
+			name: "or satisfied by either and-clause",
+			expr: `groups contains "staff" || groups contains "admins"`,
+			attrs: AssertionAttributes{
+				{FriendlyName: "groups", Value: "admins"},
+			},
+			want: true,
+		},
+		{
+			name: "multi-valued attribute matches any value",
+			expr: `groups contains "admins"`,
+			attrs: AssertionAttributes{
+				{FriendlyName: "groups", Value: "staff"},
+				{FriendlyName: "groups", Value: "admins"},
+			},
+			want: true,
+		},
+		{
+			name:  "missing attribute never matches",
+			expr:  `groups contains "staff"`,
+			attrs: AssertionAttributes{},
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := CompilePolicy(tt.expr)
+			if err != nil {
+				t.Fatalf("CompilePolicy(%q): %s", tt.expr, err)
+			}
+			if got := policy.Evaluate(tt.attrs); got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompilePolicyErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`groups`,
+		`groups contains`,
+		`groups contains staff`,
+		`groups contains "staff" &&`,
+		`groups maybe "staff"`,
+		`groups contains "unterminated`,
+		`groups contains "staff" extra`,
+	}
+
+	for _, expr := range tests {
+		if _, err := CompilePolicy(expr); err == nil {
+			t.Errorf("CompilePolicy(%q): expected error, got nil", expr)
+		}
+	}
+}
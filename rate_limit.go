@@ -0,0 +1,79 @@
+package saml
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimiter decides whether a request from ip should be allowed to
+// proceed. ServiceProviderMiddleware consults one, if set, before
+// processing a POST to the ACS endpoint.
+type RateLimiter interface {
+	Allow(ip net.IP) bool
+}
+
+// SubnetRateLimiter is a fixed-window RateLimiter keyed by IP subnet rather
+// than by individual address, following the ratelimit-subnet-len approach:
+// credential-stuffing and DoS traffic is often spread across many addresses
+// in the same /24 or /64, so limiting by subnet catches that where
+// per-IP limiting would not.
+type SubnetRateLimiter struct {
+	// MaxRequests is how many requests a subnet may make within Window.
+	MaxRequests int
+	Window      time.Duration
+
+	// SubnetLenIPv4 and SubnetLenIPv6 are the prefix lengths used to group
+	// addresses into subnets, e.g. 24 and 64. A length equal to the
+	// address's full width (32 or 128) rate-limits per individual IP.
+	SubnetLenIPv4 int
+	SubnetLenIPv6 int
+
+	mu       sync.Mutex
+	counters map[string]*rateLimitCounter
+}
+
+type rateLimitCounter struct {
+	count       int
+	windowStart time.Time
+}
+
+// NewSubnetRateLimiter returns a SubnetRateLimiter allowing maxRequests per
+// window, grouping addresses by the given IPv4/IPv6 subnet lengths.
+func NewSubnetRateLimiter(maxRequests int, window time.Duration, subnetLenIPv4, subnetLenIPv6 int) *SubnetRateLimiter {
+	return &SubnetRateLimiter{
+		MaxRequests:   maxRequests,
+		Window:        window,
+		SubnetLenIPv4: subnetLenIPv4,
+		SubnetLenIPv6: subnetLenIPv6,
+		counters:      map[string]*rateLimitCounter{},
+	}
+}
+
+// Allow implements RateLimiter.
+func (rl *SubnetRateLimiter) Allow(ip net.IP) bool {
+	key := rl.subnetKey(ip)
+	now := timeNow()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	counter, ok := rl.counters[key]
+	if !ok || now.Sub(counter.windowStart) >= rl.Window {
+		counter = &rateLimitCounter{count: 0, windowStart: now}
+		rl.counters[key] = counter
+	}
+	counter.count++
+	return counter.count <= rl.MaxRequests
+}
+
+// subnetKey returns the masked network address for ip as a string, per
+// SubnetLenIPv4/SubnetLenIPv6.
+func (rl *SubnetRateLimiter) subnetKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		mask := net.CIDRMask(rl.SubnetLenIPv4, 32)
+		return v4.Mask(mask).String()
+	}
+	mask := net.CIDRMask(rl.SubnetLenIPv6, 128)
+	return ip.Mask(mask).String()
+}
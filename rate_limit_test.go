@@ -0,0 +1,45 @@
+package saml
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSubnetRateLimiterAllowsUpToMaxRequests(t *testing.T) {
+	rl := NewSubnetRateLimiter(2, time.Minute, 32, 128)
+	ip := net.ParseIP("203.0.113.5")
+
+	if !rl.Allow(ip) {
+		t.Fatalf("request 1 should be allowed")
+	}
+	if !rl.Allow(ip) {
+		t.Fatalf("request 2 should be allowed")
+	}
+	if rl.Allow(ip) {
+		t.Fatalf("request 3 should be rejected, exceeds MaxRequests")
+	}
+}
+
+func TestSubnetRateLimiterGroupsByIPv4Subnet(t *testing.T) {
+	rl := NewSubnetRateLimiter(1, time.Minute, 24, 128)
+
+	if !rl.Allow(net.ParseIP("203.0.113.5")) {
+		t.Fatalf("first request in subnet should be allowed")
+	}
+	// Different host, same /24: counts against the same window.
+	if rl.Allow(net.ParseIP("203.0.113.200")) {
+		t.Fatalf("second host in the same /24 should share the subnet's limit")
+	}
+}
+
+func TestSubnetRateLimiterIPv4AndIPv6AreIndependent(t *testing.T) {
+	rl := NewSubnetRateLimiter(1, time.Minute, 32, 64)
+
+	if !rl.Allow(net.ParseIP("203.0.113.5")) {
+		t.Fatalf("IPv4 request should be allowed")
+	}
+	if !rl.Allow(net.ParseIP("2001:db8::1")) {
+		t.Fatalf("IPv6 request should be allowed independently of the IPv4 counter")
+	}
+}
@@ -0,0 +1,75 @@
+package saml
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ReplayCache protects the ACS endpoint against a previously-seen
+// Response/Assertion being POSTed again. Implementations key on the
+// assertion's ID and should expire entries around NotOnOrAfter, since a
+// replayed assertion older than that is already rejected on expiry grounds.
+type ReplayCache interface {
+	// Seen records that id was presented, expiring the record at expiresAt,
+	// and reports whether id had already been recorded (and not yet
+	// expired). The ACS handler rejects the request when seen is true.
+	Seen(id string, expiresAt time.Time) (seen bool, err error)
+}
+
+// MemoryReplayCache is a ReplayCache backed by an in-process, capacity-bounded
+// LRU of assertion ids. It is suitable for single-instance deployments;
+// replay protection does not survive a process restart and is not shared
+// across instances.
+type MemoryReplayCache struct {
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // least-recently-used at the back
+}
+
+type replayCacheEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+// NewMemoryReplayCache returns a MemoryReplayCache that retains at most
+// maxEntries assertion ids, evicting the least recently used entry once
+// full.
+func NewMemoryReplayCache(maxEntries int) *MemoryReplayCache {
+	return &MemoryReplayCache{
+		MaxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// Seen implements ReplayCache.
+func (c *MemoryReplayCache) Seen(id string, expiresAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		entry := elem.Value.(*replayCacheEntry)
+		if timeNow().Before(entry.expiresAt) {
+			return true, nil
+		}
+		// Expired: treat as unseen and refresh the entry below.
+		c.order.Remove(elem)
+		delete(c.entries, id)
+	}
+
+	elem := c.order.PushFront(&replayCacheEntry{id: id, expiresAt: expiresAt})
+	c.entries[id] = elem
+
+	for c.MaxEntries > 0 && c.order.Len() > c.MaxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*replayCacheEntry).id)
+	}
+	return false, nil
+}
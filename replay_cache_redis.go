@@ -0,0 +1,36 @@
+package saml
+
+import "time"
+
+// RedisReplayCache is a ReplayCache backed by Redis, using SET ... NX EX so
+// that the "has this id been seen" check and the "remember this id" write
+// happen atomically. Keys expire on their own, matching the assertion's
+// NotOnOrAfter, so there is nothing to clean up.
+type RedisReplayCache struct {
+	Conn      RedisConn
+	KeyPrefix string // default "saml:replay:"
+}
+
+// NewRedisReplayCache returns a RedisReplayCache using conn, with keys
+// prefixed by keyPrefix (default "saml:replay:" if empty).
+func NewRedisReplayCache(conn RedisConn, keyPrefix string) *RedisReplayCache {
+	if keyPrefix == "" {
+		keyPrefix = "saml:replay:"
+	}
+	return &RedisReplayCache{Conn: conn, KeyPrefix: keyPrefix}
+}
+
+// Seen implements ReplayCache.
+func (c *RedisReplayCache) Seen(id string, expiresAt time.Time) (bool, error) {
+	ttl := int(expiresAt.Sub(timeNow()).Seconds())
+	if ttl <= 0 {
+		ttl = 1
+	}
+	reply, err := c.Conn.Do("SET", c.KeyPrefix+id, "1", "NX", "EX", ttl)
+	if err != nil {
+		return false, err
+	}
+	// SET ... NX returns nil when the key already existed, meaning id has
+	// already been seen.
+	return reply == nil, nil
+}
@@ -0,0 +1,49 @@
+package saml
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SQLReplayCache is a ReplayCache backed by a database/sql table, so that
+// replay protection is shared across service provider instances. The table
+// is expected to have the following columns:
+//
+//	id         text primary key
+//	expires_at timestamp
+type SQLReplayCache struct {
+	DB        *sql.DB
+	TableName string
+}
+
+// NewSQLReplayCache returns a SQLReplayCache that stores seen assertion ids
+// in tableName (default "saml_replay_cache" if empty), using db.
+func NewSQLReplayCache(db *sql.DB, tableName string) *SQLReplayCache {
+	if tableName == "" {
+		tableName = "saml_replay_cache"
+	}
+	return &SQLReplayCache{DB: db, TableName: tableName}
+}
+
+// Seen implements ReplayCache.
+func (c *SQLReplayCache) Seen(id string, expiresAt time.Time) (bool, error) {
+	var existingExpiresAt time.Time
+	row := c.DB.QueryRow(`SELECT expires_at FROM `+c.TableName+` WHERE id = ?`, id)
+	switch err := row.Scan(&existingExpiresAt); err {
+	case nil:
+		if timeNow().Before(existingExpiresAt) {
+			return true, nil
+		}
+		// Expired: fall through and refresh the row.
+	case sql.ErrNoRows:
+		// Not seen before.
+	default:
+		return false, err
+	}
+
+	_, err := c.DB.Exec(
+		`INSERT INTO `+c.TableName+` (id, expires_at) VALUES (?, ?)
+		 ON CONFLICT(id) DO UPDATE SET expires_at = excluded.expires_at`,
+		id, expiresAt)
+	return false, err
+}
@@ -0,0 +1,66 @@
+package saml
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryReplayCacheSeen(t *testing.T) {
+	cache := NewMemoryReplayCache(10)
+	future := time.Now().Add(time.Hour)
+
+	seen, err := cache.Seen("assertion-1", future)
+	if err != nil {
+		t.Fatalf("Seen: %s", err)
+	}
+	if seen {
+		t.Fatalf("first presentation reported as already seen")
+	}
+
+	seen, err = cache.Seen("assertion-1", future)
+	if err != nil {
+		t.Fatalf("Seen: %s", err)
+	}
+	if !seen {
+		t.Fatalf("replayed assertion not reported as seen")
+	}
+}
+
+func TestMemoryReplayCacheExpiry(t *testing.T) {
+	cache := NewMemoryReplayCache(10)
+	past := time.Now().Add(-time.Hour)
+
+	if seen, err := cache.Seen("assertion-1", past); err != nil || seen {
+		t.Fatalf("Seen() = %v, %v, want false, nil", seen, err)
+	}
+
+	// The recorded expiry is already in the past, so a second
+	// presentation should be treated as unseen rather than a replay.
+	if seen, err := cache.Seen("assertion-1", time.Now().Add(time.Hour)); err != nil || seen {
+		t.Fatalf("Seen() after expiry = %v, %v, want false, nil", seen, err)
+	}
+
+	// Now that it's been refreshed with a future expiry, it is a replay.
+	if seen, err := cache.Seen("assertion-1", time.Now().Add(time.Hour)); err != nil || !seen {
+		t.Fatalf("Seen() after refresh = %v, %v, want true, nil", seen, err)
+	}
+}
+
+func TestMemoryReplayCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewMemoryReplayCache(2)
+	future := time.Now().Add(time.Hour)
+
+	cache.Seen("assertion-1", future)
+	cache.Seen("assertion-2", future)
+	cache.Seen("assertion-3", future) // should evict assertion-1
+
+	// Check assertion-2 first: Seen returns early without touching the LRU
+	// order when an entry is found and not expired, so this check alone
+	// has no side effects on what gets evicted next.
+	if seen, _ := cache.Seen("assertion-2", future); !seen {
+		t.Errorf("assertion-2 should still be cached")
+	}
+	if seen, _ := cache.Seen("assertion-1", future); seen {
+		t.Errorf("assertion-1 should have been evicted, but is still reported as seen")
+	}
+}
@@ -0,0 +1,183 @@
+package saml
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Session represents the local, server-side record of a successful SAML
+// authentication. It is created by a SessionStore when an assertion is
+// processed and is later looked up, on every request, to decide whether the
+// request is authorized.
+type Session struct {
+	NameID       string
+	SessionIndex string
+	Attributes   AssertionAttributes
+	CreatedAt    time.Time
+	LastSeenAt   time.Time
+}
+
+// ErrSessionNotFound is returned by SessionStore.Get, and by Refresh in the
+// stores that keep server-side state, when the given session id does not
+// exist, or has expired. Destroy does not return it, and
+// CookieSessionStore.Refresh is a no-op that never returns it.
+var ErrSessionNotFound = errors.New("saml: session not found")
+
+// SessionStore abstracts over where session state lives. ServiceProviderMiddleware
+// uses it in place of the original signed-cookie-only scheme so that sessions
+// can be revoked (required for SLO), so that large assertions don't bloat the
+// session cookie, and so that idle/absolute timeouts can be enforced
+// server-side.
+//
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Create records a new session for the given assertion attributes and
+	// returns an opaque id that identifies it. The id is what gets placed
+	// in the session cookie.
+	Create(assertionAttributes AssertionAttributes) (id string, err error)
+
+	// Get returns the session for id, or ErrSessionNotFound if it does not
+	// exist or has expired (idle or absolute).
+	Get(id string) (*Session, error)
+
+	// Destroy removes the session for id. It is not an error to destroy a
+	// session that does not exist.
+	Destroy(id string) error
+
+	// Refresh extends the idle timeout for the session identified by id.
+	// It is called once per authorized request.
+	Refresh(id string) error
+}
+
+// newRandomID returns a random, URL-safe session identifier.
+func newRandomID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// sessionFromAttributes builds a Session from assertion attributes, pulling
+// out the NameID and SessionIndex pseudo-attributes synthesized by
+// ParseResponse (see nameIDAttributeName and sessionIndexAttributeName).
+func sessionFromAttributes(assertionAttributes AssertionAttributes) *Session {
+	session := &Session{
+		Attributes: assertionAttributes,
+		CreatedAt:  timeNow(),
+		LastSeenAt: timeNow(),
+	}
+	for _, attr := range assertionAttributes {
+		switch attr.FriendlyName {
+		case nameIDAttributeName:
+			session.NameID = attr.Value
+		case sessionIndexAttributeName:
+			session.SessionIndex = attr.Value
+		}
+	}
+	return session
+}
+
+// MemorySessionStore is a SessionStore backed by an in-process map. It is
+// suitable for single-instance deployments or tests; sessions do not survive
+// a process restart and are not shared across instances.
+type MemorySessionStore struct {
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewMemorySessionStore returns a MemorySessionStore with the given idle and
+// absolute timeouts. A zero timeout means "no limit" for that dimension.
+func NewMemorySessionStore(idleTimeout, absoluteTimeout time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{
+		IdleTimeout:     idleTimeout,
+		AbsoluteTimeout: absoluteTimeout,
+		sessions:        map[string]*Session{},
+	}
+}
+
+// Create implements SessionStore.
+func (s *MemorySessionStore) Create(assertionAttributes AssertionAttributes) (string, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = sessionFromAttributes(assertionAttributes)
+	return id, nil
+}
+
+// Get implements SessionStore.
+func (s *MemorySessionStore) Get(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if s.expired(session) {
+		delete(s.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// Destroy implements SessionStore.
+func (s *MemorySessionStore) Destroy(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Refresh implements SessionStore.
+func (s *MemorySessionStore) Refresh(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || s.expired(session) {
+		delete(s.sessions, id)
+		return ErrSessionNotFound
+	}
+	session.LastSeenAt = timeNow()
+	return nil
+}
+
+func (s *MemorySessionStore) expired(session *Session) bool {
+	now := timeNow()
+	if s.IdleTimeout > 0 && now.Sub(session.LastSeenAt) > s.IdleTimeout {
+		return true
+	}
+	if s.AbsoluteTimeout > 0 && now.Sub(session.CreatedAt) > s.AbsoluteTimeout {
+		return true
+	}
+	return false
+}
+
+// DestroyByIdentity removes every session matching nameID and sessionIndex.
+// It is used to satisfy SessionTerminator for IdP-initiated logout, where
+// the IdP supplies the NameID/SessionIndex pair rather than our session id.
+func (s *MemorySessionStore) DestroyByIdentity(nameID, sessionIndex string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if session.NameID == nameID && session.SessionIndex == sessionIndex {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// Terminate implements SessionTerminator by deferring to DestroyByIdentity,
+// so a MemorySessionStore can be used directly as
+// ServiceProviderMiddleware.SessionTerminator.
+func (s *MemorySessionStore) Terminate(nameID, sessionIndex string) error {
+	return s.DestroyByIdentity(nameID, sessionIndex)
+}
@@ -0,0 +1,83 @@
+package saml
+
+import (
+	"encoding/pem"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// CookieSessionStore is the original SessionStore implementation: it holds
+// no server-side state at all, instead packing the assertion attributes
+// into a signed JWT and using the JWT itself as the session id, which the
+// caller stores in a cookie. This is simple and requires no shared storage,
+// but it cannot be revoked before it expires and is limited by the ~4KB
+// practical size of a cookie.
+type CookieSessionStore struct {
+	Key string // PEM-encoded private key, as ServiceProvider.Key
+}
+
+// NewCookieSessionStore returns a CookieSessionStore that signs session
+// tokens using key (a PEM-encoded private key, typically the service
+// provider's signing key).
+func NewCookieSessionStore(key string) *CookieSessionStore {
+	return &CookieSessionStore{Key: key}
+}
+
+func (s *CookieSessionStore) secret() []byte {
+	secretBlock, _ := pem.Decode([]byte(s.Key))
+	return secretBlock.Bytes
+}
+
+// Create implements SessionStore. The returned id is itself the signed
+// session token; there is nothing else to store.
+func (s *CookieSessionStore) Create(assertionAttributes AssertionAttributes) (string, error) {
+	session := sessionFromAttributes(assertionAttributes)
+
+	token := jwt.New(jwt.GetSigningMethod("HS256"))
+	for _, attr := range assertionAttributes {
+		token.Claims[attr.FriendlyName] = attr.Value
+	}
+	token.Claims["exp"] = session.CreatedAt.Add(cookieMaxAge).Unix()
+	return token.SignedString(s.secret())
+}
+
+// Get implements SessionStore by parsing and verifying id as a JWT.
+func (s *CookieSessionStore) Get(id string) (*Session, error) {
+	token, err := jwt.Parse(id, func(t *jwt.Token) (interface{}, error) {
+		return s.secret(), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrSessionNotFound
+	}
+
+	session := &Session{}
+	for claimName, claimValue := range token.Claims {
+		value, ok := claimValue.(string)
+		if !ok {
+			continue
+		}
+		session.Attributes = append(session.Attributes, AssertionAttribute{
+			FriendlyName: claimName,
+			Value:        value,
+		})
+		switch claimName {
+		case nameIDAttributeName:
+			session.NameID = value
+		case sessionIndexAttributeName:
+			session.SessionIndex = value
+		}
+	}
+	return session, nil
+}
+
+// Destroy is a no-op: a CookieSessionStore has no server-side state to
+// remove. The caller is still responsible for clearing the cookie.
+func (s *CookieSessionStore) Destroy(id string) error {
+	return nil
+}
+
+// Refresh is a no-op: the JWT's expiry was fixed at Create time and cannot
+// be extended without issuing a new token.
+func (s *CookieSessionStore) Refresh(id string) error {
+	return nil
+}
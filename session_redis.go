@@ -0,0 +1,115 @@
+package saml
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// RedisConn is the minimal subset of a Redis client that RedisSessionStore
+// needs. It is satisfied by the Do method of redigo's redis.Conn as well as
+// thin wrappers around other clients, so this package does not impose a
+// dependency on any particular Redis driver.
+type RedisConn interface {
+	Do(commandName string, args ...interface{}) (interface{}, error)
+}
+
+// RedisSessionStore is a SessionStore backed by Redis. Sessions are stored
+// as JSON under "<KeyPrefix><id>" with a TTL of IdleTimeout, so Redis itself
+// expires idle sessions; AbsoluteTimeout is enforced on read.
+type RedisSessionStore struct {
+	Conn            RedisConn
+	KeyPrefix       string
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+}
+
+// NewRedisSessionStore returns a RedisSessionStore using conn, with keys
+// prefixed by keyPrefix (default "saml:session:" if empty).
+func NewRedisSessionStore(conn RedisConn, keyPrefix string, idleTimeout, absoluteTimeout time.Duration) *RedisSessionStore {
+	if keyPrefix == "" {
+		keyPrefix = "saml:session:"
+	}
+	return &RedisSessionStore{
+		Conn:            conn,
+		KeyPrefix:       keyPrefix,
+		IdleTimeout:     idleTimeout,
+		AbsoluteTimeout: absoluteTimeout,
+	}
+}
+
+func (s *RedisSessionStore) key(id string) string {
+	return s.KeyPrefix + id
+}
+
+// Create implements SessionStore.
+func (s *RedisSessionStore) Create(assertionAttributes AssertionAttributes) (string, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+	session := sessionFromAttributes(assertionAttributes)
+	buf, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	if s.IdleTimeout > 0 {
+		_, err = s.Conn.Do("SET", s.key(id), buf, "EX", int(s.IdleTimeout.Seconds()))
+	} else {
+		_, err = s.Conn.Do("SET", s.key(id), buf)
+	}
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Get implements SessionStore.
+func (s *RedisSessionStore) Get(id string) (*Session, error) {
+	reply, err := s.Conn.Do("GET", s.key(id))
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrSessionNotFound
+	}
+	buf, ok := reply.([]byte)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	var session Session
+	if err := json.Unmarshal(buf, &session); err != nil {
+		return nil, err
+	}
+	if s.AbsoluteTimeout > 0 && timeNow().Sub(session.CreatedAt) > s.AbsoluteTimeout {
+		s.Destroy(id)
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+// Destroy implements SessionStore.
+func (s *RedisSessionStore) Destroy(id string) error {
+	_, err := s.Conn.Do("DEL", s.key(id))
+	return err
+}
+
+// Refresh implements SessionStore.
+func (s *RedisSessionStore) Refresh(id string) error {
+	session, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	session.LastSeenAt = timeNow()
+	buf, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if s.IdleTimeout > 0 {
+		_, err = s.Conn.Do("SET", s.key(id), buf, "EX", int(s.IdleTimeout.Seconds()))
+	} else {
+		_, err = s.Conn.Do("SET", s.key(id), buf)
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package saml
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// SQLSessionStore is a SessionStore backed by a database/sql table. It
+// allows session state to be shared across service provider instances and
+// to survive process restarts, at the cost of a round-trip per request.
+//
+// The table is expected to have the following columns; use SQLSessionSchema
+// as a starting point, adjusted for your driver's DDL dialect:
+//
+//	id            text primary key
+//	name_id       text
+//	session_index text
+//	attributes    text   -- JSON-encoded AssertionAttributes
+//	created_at    timestamp
+//	last_seen_at  timestamp
+type SQLSessionStore struct {
+	DB              *sql.DB
+	TableName       string
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+}
+
+// SQLSessionSchema is an example CREATE TABLE statement for the default
+// table name "saml_sessions". Drivers vary in their column type names, so
+// adjust before use.
+const SQLSessionSchema = `CREATE TABLE saml_sessions (
+	id            VARCHAR(255) PRIMARY KEY,
+	name_id       VARCHAR(255) NOT NULL,
+	session_index VARCHAR(255) NOT NULL,
+	attributes    TEXT NOT NULL,
+	created_at    TIMESTAMP NOT NULL,
+	last_seen_at  TIMESTAMP NOT NULL
+)`
+
+// NewSQLSessionStore returns a SQLSessionStore that stores sessions in
+// tableName (default "saml_sessions" if empty), using db.
+func NewSQLSessionStore(db *sql.DB, tableName string, idleTimeout, absoluteTimeout time.Duration) *SQLSessionStore {
+	if tableName == "" {
+		tableName = "saml_sessions"
+	}
+	return &SQLSessionStore{
+		DB:              db,
+		TableName:       tableName,
+		IdleTimeout:     idleTimeout,
+		AbsoluteTimeout: absoluteTimeout,
+	}
+}
+
+// Create implements SessionStore.
+func (s *SQLSessionStore) Create(assertionAttributes AssertionAttributes) (string, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+	session := sessionFromAttributes(assertionAttributes)
+
+	attrs, err := json.Marshal(session.Attributes)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.DB.Exec(
+		`INSERT INTO `+s.TableName+` (id, name_id, session_index, attributes, created_at, last_seen_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		id, session.NameID, session.SessionIndex, attrs, session.CreatedAt, session.LastSeenAt)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Get implements SessionStore.
+func (s *SQLSessionStore) Get(id string) (*Session, error) {
+	var session Session
+	var attrs []byte
+	row := s.DB.QueryRow(
+		`SELECT name_id, session_index, attributes, created_at, last_seen_at
+		 FROM `+s.TableName+` WHERE id = ?`, id)
+	if err := row.Scan(&session.NameID, &session.SessionIndex, &attrs, &session.CreatedAt, &session.LastSeenAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(attrs, &session.Attributes); err != nil {
+		return nil, err
+	}
+
+	now := timeNow()
+	if s.IdleTimeout > 0 && now.Sub(session.LastSeenAt) > s.IdleTimeout {
+		s.Destroy(id)
+		return nil, ErrSessionNotFound
+	}
+	if s.AbsoluteTimeout > 0 && now.Sub(session.CreatedAt) > s.AbsoluteTimeout {
+		s.Destroy(id)
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+// Destroy implements SessionStore.
+func (s *SQLSessionStore) Destroy(id string) error {
+	_, err := s.DB.Exec(`DELETE FROM `+s.TableName+` WHERE id = ?`, id)
+	return err
+}
+
+// Refresh implements SessionStore.
+func (s *SQLSessionStore) Refresh(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	result, err := s.DB.Exec(`UPDATE `+s.TableName+` SET last_seen_at = ? WHERE id = ?`, timeNow(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// Terminate implements SessionTerminator so a SQLSessionStore can be used
+// directly as ServiceProviderMiddleware.SessionTerminator.
+func (s *SQLSessionStore) Terminate(nameID, sessionIndex string) error {
+	_, err := s.DB.Exec(
+		`DELETE FROM `+s.TableName+` WHERE name_id = ? AND session_index = ?`,
+		nameID, sessionIndex)
+	return err
+}
@@ -0,0 +1,153 @@
+package saml
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStoreCreateGetDestroy(t *testing.T) {
+	store := NewMemorySessionStore(0, 0)
+
+	id, err := store.Create(AssertionAttributes{
+		{FriendlyName: nameIDAttributeName, Value: "alice@example.com"},
+		{FriendlyName: sessionIndexAttributeName, Value: "abc123"},
+	})
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+
+	session, err := store.Get(id)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if session.NameID != "alice@example.com" {
+		t.Errorf("session.NameID = %q, want %q", session.NameID, "alice@example.com")
+	}
+	if session.SessionIndex != "abc123" {
+		t.Errorf("session.SessionIndex = %q, want %q", session.SessionIndex, "abc123")
+	}
+
+	if err := store.Destroy(id); err != nil {
+		t.Fatalf("Destroy: %s", err)
+	}
+	if _, err := store.Get(id); err != ErrSessionNotFound {
+		t.Errorf("Get after Destroy: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestMemorySessionStoreGetUnknownID(t *testing.T) {
+	store := NewMemorySessionStore(0, 0)
+	if _, err := store.Get("does-not-exist"); err != ErrSessionNotFound {
+		t.Errorf("Get: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestMemorySessionStoreDestroyUnknownIDIsNotAnError(t *testing.T) {
+	store := NewMemorySessionStore(0, 0)
+	if err := store.Destroy("does-not-exist"); err != nil {
+		t.Errorf("Destroy: %s", err)
+	}
+}
+
+func TestMemorySessionStoreIdleTimeout(t *testing.T) {
+	store := NewMemorySessionStore(time.Minute, 0)
+
+	id, err := store.Create(nil)
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+
+	store.mu.Lock()
+	store.sessions[id].LastSeenAt = timeNow().Add(-2 * time.Minute)
+	store.mu.Unlock()
+
+	if _, err := store.Get(id); err != ErrSessionNotFound {
+		t.Errorf("Get after idle timeout: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestMemorySessionStoreAbsoluteTimeout(t *testing.T) {
+	store := NewMemorySessionStore(0, time.Minute)
+
+	id, err := store.Create(nil)
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+
+	store.mu.Lock()
+	store.sessions[id].CreatedAt = timeNow().Add(-2 * time.Minute)
+	store.mu.Unlock()
+
+	// Refresh only extends the idle timeout; it must not override an
+	// expired absolute timeout.
+	if err := store.Refresh(id); err != ErrSessionNotFound {
+		t.Errorf("Refresh after absolute timeout: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestMemorySessionStoreRefreshExtendsIdleTimeout(t *testing.T) {
+	store := NewMemorySessionStore(time.Minute, 0)
+
+	id, err := store.Create(nil)
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+
+	store.mu.Lock()
+	store.sessions[id].LastSeenAt = timeNow().Add(-30 * time.Second)
+	store.mu.Unlock()
+
+	if err := store.Refresh(id); err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+	if _, err := store.Get(id); err != nil {
+		t.Errorf("Get after Refresh: %s", err)
+	}
+}
+
+func TestMemorySessionStoreDestroyByIdentity(t *testing.T) {
+	store := NewMemorySessionStore(0, 0)
+
+	id1, _ := store.Create(AssertionAttributes{
+		{FriendlyName: nameIDAttributeName, Value: "alice@example.com"},
+		{FriendlyName: sessionIndexAttributeName, Value: "session-1"},
+	})
+	id2, _ := store.Create(AssertionAttributes{
+		{FriendlyName: nameIDAttributeName, Value: "alice@example.com"},
+		{FriendlyName: sessionIndexAttributeName, Value: "session-2"},
+	})
+	otherID, _ := store.Create(AssertionAttributes{
+		{FriendlyName: nameIDAttributeName, Value: "bob@example.com"},
+		{FriendlyName: sessionIndexAttributeName, Value: "session-3"},
+	})
+
+	if err := store.DestroyByIdentity("alice@example.com", "session-1"); err != nil {
+		t.Fatalf("DestroyByIdentity: %s", err)
+	}
+
+	if _, err := store.Get(id1); err != ErrSessionNotFound {
+		t.Errorf("Get(id1) after DestroyByIdentity: err = %v, want ErrSessionNotFound", err)
+	}
+	if _, err := store.Get(id2); err != nil {
+		t.Errorf("Get(id2) should be unaffected by a different SessionIndex: %s", err)
+	}
+	if _, err := store.Get(otherID); err != nil {
+		t.Errorf("Get(otherID) should be unaffected by a different NameID: %s", err)
+	}
+}
+
+func TestMemorySessionStoreTerminateIsDestroyByIdentity(t *testing.T) {
+	store := NewMemorySessionStore(0, 0)
+
+	id, _ := store.Create(AssertionAttributes{
+		{FriendlyName: nameIDAttributeName, Value: "alice@example.com"},
+		{FriendlyName: sessionIndexAttributeName, Value: "session-1"},
+	})
+
+	if err := store.Terminate("alice@example.com", "session-1"); err != nil {
+		t.Fatalf("Terminate: %s", err)
+	}
+	if _, err := store.Get(id); err != ErrSessionNotFound {
+		t.Errorf("Get after Terminate: err = %v, want ErrSessionNotFound", err)
+	}
+}